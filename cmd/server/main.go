@@ -13,15 +13,24 @@ import (
 	"pg-backup/internal/backup"
 	"pg-backup/internal/config"
 	"pg-backup/internal/scheduler"
+	"pg-backup/internal/storage"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	_ "github.com/lib/pq"
 )
 
+// metadataDSN 备份元数据库的连接串
+const metadataDSN = "host=localhost port=5432 user=postgres dbname=backup_manager sslmode=disable"
+
 func main() {
+	// archive_command / restore_command 调用的子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "archive-wal", "restore-wal":
+			os.Exit(runWALCommand(os.Args[1], os.Args[2:]))
+		}
+	}
+
 	// 解析命令行参数
 	configPath := flag.String("config", "", "配置文件路径")
 	flag.Parse()
@@ -33,7 +42,7 @@ func main() {
 	}
 
 	// 连接数据库
-	db, err := sql.Open("postgres", "host=localhost port=5432 user=postgres dbname=backup_manager sslmode=disable")
+	db, err := sql.Open("postgres", metadataDSN)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -45,36 +54,33 @@ func main() {
 
 	// 初始化 S3 客户端
 	var s3Client *s3.Client
-	if cfg.Storage.Type == "s3" && cfg.Storage.S3.AccessKey != "" {
-		s3Cfg, err := awsconfig.LoadDefaultConfig(context.TODO(),
-			awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-				cfg.Storage.S3.AccessKey,
-				cfg.Storage.S3.SecretKey,
-				"",
-			)),
-			awsconfig.WithRegion(cfg.Storage.S3.Region),
-		)
+	if cfg.Storage.Type == "s3" {
+		s3Client, err = storage.NewS3Client(context.Background(), &cfg.Storage.S3)
 		if err != nil {
-			log.Printf("Failed to load S3 config: %v", err)
-		} else {
-			s3Client = s3.NewFromConfig(s3Cfg, func(o *s3.Options) {
-				if cfg.Storage.S3.Endpoint != "" {
-					o.BaseEndpoint = aws.String(cfg.Storage.S3.Endpoint)
-					o.UsePathStyle = true
-				}
-			})
+			log.Printf("Failed to create S3 client: %v", err)
 		}
 	}
+	storageService := storage.New(&cfg.Storage)
+	storageService.SetS3Client(s3Client)
 
 	// 初始化服务
 	backupService := backup.New(db, cfg, s3Client)
 	schedulerService := scheduler.New(db, backupService)
-	apiServer := api.New(db, cfg, backupService, schedulerService)
+	apiServer := api.New(db, cfg, backupService, schedulerService, storageService)
+
+	// 启动定时任务
+	if err := schedulerService.Start(); err != nil {
+		log.Printf("Failed to start scheduler: %v", err)
+	}
+	defer schedulerService.Stop()
 
-	// 加载定时任务
-	if err := schedulerService.LoadJobs(); err != nil {
-		log.Printf("Failed to load scheduled jobs: %v", err)
+	// 启动后台任务：WAL 接收、过期克隆清理
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if cfg.WAL.Receive {
+		go backupService.RunWALReceiver(ctx)
 	}
+	go backupService.RunCloneReaper(ctx)
 
 	// 启动 API 服务器
 	go func() {
@@ -89,4 +95,53 @@ func main() {
 	<-sigChan
 
 	log.Println("Shutting down...")
-}
\ No newline at end of file
+}
+
+// runWALCommand 执行 archive-wal <path> <name> 或 restore-wal <name> <dest>，返回进程退出码
+func runWALCommand(command string, args []string) int {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	configPath := fs.String("config", "", "配置文件路径")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		if command == "archive-wal" {
+			log.Printf("usage: %s archive-wal [-config path] <path> <name>", os.Args[0])
+		} else {
+			log.Printf("usage: %s restore-wal [-config path] <name> <dest>", os.Args[0])
+		}
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Printf("Failed to load config: %v", err)
+		return 1
+	}
+
+	db, err := sql.Open("postgres", metadataDSN)
+	if err != nil {
+		log.Printf("Failed to connect to database: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	var s3Client *s3.Client
+	if cfg.Storage.Type == "s3" {
+		if s3Client, err = storage.NewS3Client(ctx, &cfg.Storage.S3); err != nil {
+			log.Printf("Failed to create S3 client: %v", err)
+			return 1
+		}
+	}
+	backupService := backup.New(db, cfg, s3Client)
+
+	if command == "archive-wal" {
+		err = backupService.ArchiveWAL(ctx, fs.Arg(0), fs.Arg(1))
+	} else {
+		err = backupService.FetchWAL(ctx, fs.Arg(0), fs.Arg(1))
+	}
+	if err != nil {
+		log.Printf("%s failed: %v", command, err)
+		return 1
+	}
+	return 0
+}
@@ -3,6 +3,9 @@ package api
 import (
     "database/sql"
     "context" // 👈 新增：用于 Stop() 中的 context
+    "errors"
+    "fmt"
+    "log"
     "net/http"
     "strconv"
     "time"
@@ -10,16 +13,50 @@ import (
     "pg-backup/internal/backup"
     "pg-backup/internal/config"
     "pg-backup/internal/scheduler"
+    "pg-backup/internal/storage"
 
     "github.com/gin-contrib/cors"
     "github.com/gin-gonic/gin"
 )
 
 type BackupRequest struct {
-    Type          string `json:"type" binding:"required,oneof=local s3"`
-    IncludeData   bool   `json:"includeData"`
-    IncludeSchema bool   `json:"includeSchema"`
-    Compression   bool   `json:"compression"`
+    Type          string                    `json:"type" binding:"required,oneof=local s3"`
+    Kind          string                    `json:"kind" binding:"omitempty,oneof=logical physical incremental"`
+    IncludeData   bool                      `json:"includeData"`
+    IncludeSchema bool                      `json:"includeSchema"`
+    Compression   bool                      `json:"compression"`
+    Compress      *config.CompressionConfig `json:"compress"`
+    UploadLimit   int64                     `json:"uploadLimit"`
+    VolumeSize    int64                     `json:"volumeSize"`
+    Repository    bool                      `json:"repository"`
+    Dump          *config.DumpConfig        `json:"dump"`
+    Physical      *backup.PhysicalOptions   `json:"physical"`
+    Selection     *backup.Selection         `json:"selection"`
+    Format        string                    `json:"format" binding:"omitempty,oneof=plain custom"`
+    SkipPreflight bool                      `json:"skipPreflight"`
+    Labels        []string                  `json:"labels"`
+    Notes         string                    `json:"notes"`
+}
+
+// options 转换为备份服务的参数
+func (req BackupRequest) options() backup.Options {
+    return backup.Options{
+        Kind:          req.Kind,
+        Physical:      req.Physical,
+        IncludeData:   req.IncludeData,
+        IncludeSchema: req.IncludeSchema,
+        Compression:   req.Compression,
+        Compress:      req.Compress,
+        UploadLimit:   req.UploadLimit,
+        VolumeSize:    req.VolumeSize,
+        Repository:    req.Repository,
+        Dump:          req.Dump,
+        Selection:     req.Selection,
+        Format:        req.Format,
+        SkipPreflight: req.SkipPreflight,
+        Labels:        req.Labels,
+        Notes:         req.Notes,
+    }
 }
 
 type APIServer struct {
@@ -27,11 +64,12 @@ type APIServer struct {
     config           *config.Config
     backupService    *backup.Service
     schedulerService *scheduler.Service
+    storageService   *storage.Service
     router           *gin.Engine
     httpServer       *http.Server // 👈 新增字段，用于优雅关闭
 }
 
-func New(db *sql.DB, cfg *config.Config, backupService *backup.Service, schedulerService *scheduler.Service) *APIServer {
+func New(db *sql.DB, cfg *config.Config, backupService *backup.Service, schedulerService *scheduler.Service, storageService *storage.Service) *APIServer {
     gin.SetMode(gin.ReleaseMode)
     router := gin.New()
     router.Use(gin.Logger(), gin.Recovery())
@@ -51,6 +89,7 @@ func New(db *sql.DB, cfg *config.Config, backupService *backup.Service, schedule
         config:           cfg,
         backupService:    backupService,
         schedulerService: schedulerService,
+        storageService:   storageService,
         router:           router,
     }
 
@@ -85,8 +124,41 @@ func (s *APIServer) setupRoutes() {
         // 备份相关路由
         api.POST("/backup", s.createBackup)
         api.GET("/backups", s.getBackupHistory)
+        api.PATCH("/backups/:id", s.annotateBackup)
         api.DELETE("/backups/:id", s.deleteBackup)
+        api.GET("/backups/progress", s.getBackupProgress)
+        api.POST("/backups/preflight", s.preflightBackup)
         api.GET("/backups/:id/download", s.downloadBackup)
+        api.GET("/backups/:id/contents", s.getBackupContents)
+        api.GET("/backups/:id/diff/:other", s.diffBackups)
+        api.GET("/backups/:id/stats", s.getBackupStats)
+        api.GET("/backups/:id/log", s.getBackupRunLog)
+        api.POST("/backups/:id/restore", s.restoreBackup)
+        api.POST("/backups/:id/pin", s.pinBackup)
+        api.DELETE("/backups/:id/pin", s.unpinBackup)
+
+        // 一致性组：多个数据库基于同一快照的备份
+        api.POST("/groups", s.createConsistencyGroup)
+        api.GET("/groups", s.getConsistencyGroups)
+
+        // WAL 归档与时间点恢复
+        api.GET("/wal", s.getWALTimelines)
+        api.GET("/wal/:timeline", s.getWALSegments)
+        api.POST("/pitr", s.restorePointInTime)
+
+        // 目标与克隆
+        api.GET("/targets", s.getTargets)
+        api.POST("/targets/:id/clone", s.cloneTarget)
+        api.GET("/targets/:id/trend", s.getTableTrend)
+        api.GET("/clones", s.getClones)
+        api.DELETE("/clones/:id", s.dropClone)
+
+        // 脱敏相关路由
+        api.GET("/masking/profiles", s.getMaskingProfiles)
+        api.POST("/masking/profiles", s.createMaskingProfile)
+        api.DELETE("/masking/profiles/:id", s.deleteMaskingProfile)
+        api.POST("/masking/apply", s.applyMasking)
+        api.GET("/masking/audit", s.getMaskingAudit)
 
         // 定时任务相关路由
         api.GET("/jobs", s.getScheduledJobs)
@@ -94,6 +166,11 @@ func (s *APIServer) setupRoutes() {
         api.PUT("/jobs/:id", s.updateScheduledJob)
         api.DELETE("/jobs/:id", s.deleteScheduledJob)
         api.POST("/jobs/:id/toggle", s.toggleScheduledJob)
+        api.GET("/jobs/:id/runs", s.getJobRuns)
+        api.POST("/jobs/:id/run", s.runScheduledJob)
+
+        // 存储相关路由
+        api.POST("/storage/test", s.testStorage)
 
         // 配置相关路由
         api.GET("/config", s.getConfigurations)
@@ -113,24 +190,108 @@ func (s *APIServer) createBackup(c *gin.Context) {
         return
     }
 
+    opts := req.options()
+    if err := s.backupService.ValidateOptions(opts); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
     // 异步执行备份
     go func() {
-        if err := s.backupService.CreateBackup(req.IncludeData, req.IncludeSchema, req.Compression); err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
+        if err := s.backupService.CreateBackup(opts); err != nil {
+            log.Printf("Backup failed: %v", err)
         }
     }()
 
     c.JSON(http.StatusAccepted, gin.H{"message": "备份任务已启动"})
 }
 
+// preflightBackup 只执行备份前的预检，返回 pass/warn/fail 报告
+func (s *APIServer) preflightBackup(c *gin.Context) {
+    var req BackupRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    report := s.backupService.Preflight(c.Request.Context(), req.options())
+    c.JSON(http.StatusOK, report)
+}
+
+// getBackupHistory 分页查询备份历史，支持按状态、目标、任务、标签、时间和大小筛选
 func (s *APIServer) getBackupHistory(c *gin.Context) {
-    records, err := s.backupService.GetBackupHistory()
+    var filter backup.BackupFilter
+    if err := c.ShouldBindQuery(&filter); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    page, err := s.backupService.GetBackupHistory(c.Request.Context(), filter)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
-    c.JSON(http.StatusOK, records)
+    c.JSON(http.StatusOK, page)
+}
+
+// annotateBackup 修改备份的标签和备注
+func (s *APIServer) annotateBackup(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup ID"})
+        return
+    }
+
+    var req backup.BackupAnnotation
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    if err := s.backupService.AnnotateBackup(c.Request.Context(), id, req); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Backup updated successfully"})
+}
+
+// pinBackup 固定备份，使其不受保留策略清理，未指定 by 时记录请求方地址
+func (s *APIServer) pinBackup(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup ID"})
+        return
+    }
+
+    var req backup.PinRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if req.By == "" {
+        req.By = c.ClientIP()
+    }
+
+    pin, err := s.backupService.PinBackup(c.Request.Context(), id, req)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, pin)
+}
+
+func (s *APIServer) unpinBackup(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup ID"})
+        return
+    }
+
+    if err := s.backupService.UnpinBackup(c.Request.Context(), id); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Backup unpinned successfully"})
 }
 
 func (s *APIServer) deleteBackup(c *gin.Context) {
@@ -155,13 +316,335 @@ func (s *APIServer) downloadBackup(c *gin.Context) {
         return
     }
 
-    data, err := s.backupService.DownloadBackup(id)
+    // limit 参数覆盖配置中的下载限速（字节/秒）
+    var limit int64
+    if v := c.Query("limit"); v != "" {
+        if limit, err = strconv.ParseInt(v, 10, 64); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+            return
+        }
+    }
+
+    // decompress=true 时返回解压后的数据
+    decompress := c.Query("decompress") == "true"
+
+    reader, filename, err := s.backupService.DownloadBackup(c.Request.Context(), id, limit, decompress)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
+    defer reader.Close()
 
-    c.Data(http.StatusOK, "application/octet-stream", data)
+    c.DataFromReader(http.StatusOK, -1, "application/octet-stream", reader, map[string]string{
+        "Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, filename),
+    })
+}
+
+func (s *APIServer) getBackupContents(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup ID"})
+        return
+    }
+
+    contents, err := s.backupService.GetBackupContents(c.Request.Context(), id)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, contents)
+}
+
+func (s *APIServer) diffBackups(c *gin.Context) {
+    from, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup ID"})
+        return
+    }
+    to, err := strconv.ParseInt(c.Param("other"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup ID"})
+        return
+    }
+
+    diff, err := s.backupService.DiffBackups(c.Request.Context(), from, to)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, diff)
+}
+
+func (s *APIServer) getBackupStats(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup ID"})
+        return
+    }
+
+    // top 参数指定返回最大的多少张表，默认 20
+    var top int
+    if v := c.Query("top"); v != "" {
+        if top, err = strconv.Atoi(v); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid top"})
+            return
+        }
+    }
+
+    stats, err := s.backupService.GetBackupStats(c.Request.Context(), id, top)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, stats)
+}
+
+// getBackupRunLog 返回备份的运行日志（钩子的执行结果和输出）
+func (s *APIServer) getBackupRunLog(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup ID"})
+        return
+    }
+
+    runLog, err := s.backupService.GetRunLog(c.Request.Context(), id)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.String(http.StatusOK, runLog)
+}
+
+func (s *APIServer) restoreBackup(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup ID"})
+        return
+    }
+
+    var req backup.RestoreOptions
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    if err := s.backupService.Restore(c.Request.Context(), id, req); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Backup restored successfully"})
+}
+
+func (s *APIServer) getBackupProgress(c *gin.Context) {
+    c.JSON(http.StatusOK, s.backupService.GetProgress())
+}
+
+// WAL 归档相关处理函数
+func (s *APIServer) getWALTimelines(c *gin.Context) {
+    timelines, err := s.backupService.GetWALTimelines(c.Request.Context())
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, timelines)
+}
+
+func (s *APIServer) getWALSegments(c *gin.Context) {
+    timeline, err := strconv.Atoi(c.Param("timeline"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timeline"})
+        return
+    }
+
+    segments, err := s.backupService.GetWALSegments(c.Request.Context(), timeline)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, segments)
+}
+
+func (s *APIServer) restorePointInTime(c *gin.Context) {
+    var req backup.PITROptions
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    baseID, err := s.backupService.RestorePointInTime(c.Request.Context(), req)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "backupId": baseID})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "message":  "Data directory prepared, start PostgreSQL to replay WAL",
+        "backupId": baseID,
+    })
+}
+
+// 一致性组相关处理函数
+func (s *APIServer) createConsistencyGroup(c *gin.Context) {
+    var req backup.GroupRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    group, err := s.backupService.CreateConsistentBackup(req)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusAccepted, group)
+}
+
+func (s *APIServer) getConsistencyGroups(c *gin.Context) {
+    groups, err := s.backupService.GetConsistencyGroups(c.Request.Context())
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, groups)
+}
+
+// 目标与克隆相关处理函数
+func (s *APIServer) getTargets(c *gin.Context) {
+    targets, err := s.backupService.GetTargets(c.Request.Context())
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, targets)
+}
+
+// getTableTrend 返回 table 参数指定的表在最近 limit 次备份中的行数和大小
+func (s *APIServer) getTableTrend(c *gin.Context) {
+    table := c.Query("table")
+    if table == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "table is required"})
+        return
+    }
+    var limit int
+    if v := c.Query("limit"); v != "" {
+        var err error
+        if limit, err = strconv.Atoi(v); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+            return
+        }
+    }
+
+    points, err := s.backupService.GetTableTrend(c.Request.Context(), c.Param("id"), table, limit)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, points)
+}
+
+func (s *APIServer) cloneTarget(c *gin.Context) {
+    var req backup.CloneRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    clone, err := s.backupService.CloneTarget(c.Request.Context(), c.Param("id"), req)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusAccepted, clone)
+}
+
+func (s *APIServer) getClones(c *gin.Context) {
+    clones, err := s.backupService.GetClones(c.Request.Context())
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, clones)
+}
+
+func (s *APIServer) dropClone(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid clone ID"})
+        return
+    }
+
+    if err := s.backupService.DropClone(c.Request.Context(), id); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Clone dropped successfully"})
+}
+
+// 脱敏相关处理函数
+func (s *APIServer) getMaskingProfiles(c *gin.Context) {
+    profiles, err := s.backupService.GetMaskingProfiles(c.Request.Context())
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, profiles)
+}
+
+func (s *APIServer) createMaskingProfile(c *gin.Context) {
+    var profile backup.MaskingProfile
+    if err := c.ShouldBindJSON(&profile); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    if err := s.backupService.CreateMaskingProfile(c.Request.Context(), &profile); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusCreated, profile)
+}
+
+func (s *APIServer) deleteMaskingProfile(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid profile ID"})
+        return
+    }
+
+    if err := s.backupService.DeleteMaskingProfile(c.Request.Context(), id); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Masking profile deleted successfully"})
+}
+
+func (s *APIServer) applyMasking(c *gin.Context) {
+    var req struct {
+        Profile  string `json:"profile" binding:"required"`
+        Server   string `json:"server"`
+        Database string `json:"database" binding:"required"`
+        BackupID int64  `json:"backupId"`
+    }
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    if err := s.backupService.ApplyMasking(c.Request.Context(), req.Profile, req.Server, req.Database, req.BackupID); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Masking applied successfully"})
+}
+
+func (s *APIServer) getMaskingAudit(c *gin.Context) {
+    records, err := s.backupService.GetMaskingAudit(c.Request.Context())
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, records)
 }
 
 // 定时任务相关处理函数
@@ -182,16 +665,53 @@ func (s *APIServer) createScheduledJob(c *gin.Context) {
     }
 
     if err := s.schedulerService.CreateJob(&job); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
         return
     }
 
     c.JSON(http.StatusCreated, job)
 }
 
+// jobErrorStatus 定时任务错误对应的 HTTP 状态码
+func jobErrorStatus(err error) int {
+    switch {
+    case errors.Is(err, scheduler.ErrInvalidJob):
+        return http.StatusBadRequest
+    case errors.Is(err, scheduler.ErrJobNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, scheduler.ErrVersionConflict), errors.Is(err, scheduler.ErrJobRunning):
+        return http.StatusConflict
+    default:
+        return http.StatusInternalServerError
+    }
+}
+
+// updateScheduledJob 更新定时任务，请求中的 version 须为读取时的版本，任务已被修改时返回 409
 func (s *APIServer) updateScheduledJob(c *gin.Context) {
-    // TODO: 实现更新定时任务的逻辑
-    c.JSON(http.StatusOK, gin.H{"message": "Job updated"})
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+        return
+    }
+
+    var job scheduler.ScheduledJob
+    if err := c.ShouldBindJSON(&job); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    job.ID = id
+
+    if err := s.schedulerService.UpdateJob(&job); err != nil {
+        c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    updated, err := s.schedulerService.GetJob(id)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, updated)
 }
 
 func (s *APIServer) deleteScheduledJob(c *gin.Context) {
@@ -202,7 +722,7 @@ func (s *APIServer) deleteScheduledJob(c *gin.Context) {
     }
 
     if err := s.schedulerService.DeleteJob(id); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
         return
     }
 
@@ -218,13 +738,64 @@ func (s *APIServer) toggleScheduledJob(c *gin.Context) {
 
     newStatus, err := s.schedulerService.ToggleJob(id)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
         return
     }
 
     c.JSON(http.StatusOK, gin.H{"enabled": newStatus})
 }
 
+// runScheduledJob 立即按任务保存的参数运行一次，任务正在运行时返回 409
+func (s *APIServer) runScheduledJob(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+        return
+    }
+
+    if err := s.schedulerService.RunJobNow(id); err != nil {
+        c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusAccepted, gin.H{"message": "任务已开始运行"})
+}
+
+// getJobRuns 返回任务最近的运行记录，limit 默认 50
+func (s *APIServer) getJobRuns(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+        return
+    }
+    var limit int
+    if v := c.Query("limit"); v != "" {
+        if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+            return
+        }
+    }
+
+    runs, err := s.schedulerService.GetJobRuns(id, limit)
+    if err != nil {
+        c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, runs)
+}
+
+// 存储相关处理函数
+func (s *APIServer) testStorage(c *gin.Context) {
+    ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+    defer cancel()
+
+    result := s.storageService.TestConnection(ctx)
+    if !result.Success {
+        c.JSON(http.StatusBadGateway, result)
+        return
+    }
+    c.JSON(http.StatusOK, result)
+}
+
 // 配置相关处理函数
 func (s *APIServer) getConfigurations(c *gin.Context) {
     c.JSON(http.StatusOK, s.config)
@@ -236,6 +807,10 @@ func (s *APIServer) updateConfigurations(c *gin.Context) {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid configuration payload"})
         return
     }
+    if err := newConfig.Validate(); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
 
     // TODO: 实现配置更新逻辑
     c.JSON(http.StatusOK, gin.H{"message": "Configuration updated"})
@@ -267,10 +842,17 @@ func (s *APIServer) getStats(c *gin.Context) {
     s.db.QueryRow("SELECT COUNT(*) FROM backup_records WHERE status = 'failed'").Scan(&failedBackups)
     s.db.QueryRow("SELECT COUNT(*) FROM scheduled_jobs WHERE enabled = true").Scan(&activeJobs)
 
-    c.JSON(http.StatusOK, gin.H{
+    stats := gin.H{
         "totalBackups":      totalBackups,
         "successfulBackups": successfulBackups,
         "failedBackups":     failedBackups,
         "activeJobs":        activeJobs,
-    })
+    }
+
+    // 去重仓库的逻辑大小与实际占用
+    if repoStats, err := s.backupService.GetRepositoryStats(c.Request.Context()); err == nil {
+        stats["repository"] = repoStats
+    }
+
+    c.JSON(http.StatusOK, stats)
 }
\ No newline at end of file
@@ -14,6 +14,8 @@ import (
 	"pg-backup/internal/backup"
 	"pg-backup/internal/config"
 	"pg-backup/internal/scheduler"
+	"pg-backup/internal/storage"
+	"pg-backup/pkg/utils"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	_ "github.com/lib/pq" // PostgreSQL driver
@@ -24,9 +26,11 @@ type App struct {
 	cfg           *config.Config
 	db            *sql.DB
 	s3Client      *s3.Client
+	storage       *storage.Service
 	backupService *backup.Service
 	scheduler     *scheduler.Service
 	apiServer     *api.APIServer
+	cancel        context.CancelFunc
 }
 
 // Run 启动整个应用程序
@@ -67,10 +71,20 @@ func (a *App) start() error {
 	if cfg.Storage.Type == "s3" {
 		a.s3Client = a.initS3Client()
 	}
+	a.storage = storage.New(&a.cfg.Storage)
+	a.storage.SetS3Client(a.s3Client)
 
 	// 初始化备份服务
 	a.backupService = backup.New(a.db, a.cfg, a.s3Client)
 
+	// 启动后台任务：WAL 接收（如果启用）、过期克隆清理
+	var bgCtx context.Context
+	bgCtx, a.cancel = context.WithCancel(context.Background())
+	if cfg.WAL.Receive {
+		go a.backupService.RunWALReceiver(bgCtx)
+	}
+	go a.backupService.RunCloneReaper(bgCtx)
+
 	// 初始化定时任务服务
 	a.scheduler = scheduler.New(a.db, a.backupService)
 	if err := a.scheduler.Start(); err != nil {
@@ -78,7 +92,7 @@ func (a *App) start() error {
 	}
 
 	// 初始化并启动 API 服务
-	a.apiServer = api.New(a.db, a.cfg, a.backupService, a.scheduler)
+	a.apiServer = api.New(a.db, a.cfg, a.backupService, a.scheduler, a.storage)
 	if err := a.apiServer.Start(); err != nil {
 		return fmt.Errorf("failed to start API server: %w", err)
 	}
@@ -103,6 +117,11 @@ func (a *App) stop() error {
 		a.scheduler.Stop()
 	}
 
+	// 停止后台任务
+	if a.cancel != nil {
+		a.cancel()
+	}
+
 	// 关闭数据库连接
 	if a.db != nil {
 		if err := a.db.Close(); err != nil {
@@ -118,16 +137,13 @@ func (a *App) stop() error {
 
 // initDatabase 初始化数据库连接
 func (a *App) initDatabase() (*sql.DB, error) {
-	dsn := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		a.cfg.Database.Host,
-		a.cfg.Database.Port,
-		a.cfg.Database.Username,
-		a.cfg.Database.Password,
-		a.cfg.Database.Database,
-	)
-
-	db, err := sql.Open("postgres", dsn)
+	db, err := utils.OpenDatabase(utils.DBConfig{
+		Host:     a.cfg.Database.Host,
+		Port:     a.cfg.Database.Port,
+		User:     a.cfg.Database.Username,
+		Password: a.cfg.Database.Password,
+		DBName:   a.cfg.Database.Database,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -143,18 +159,12 @@ func (a *App) initDatabase() (*sql.DB, error) {
 	return db, nil
 }
 
-// initS3Client 初始化 S3 客户端（示例）
+// initS3Client 初始化 S3 客户端，支持静态密钥、默认凭证链和 AssumeRole
 func (a *App) initS3Client() *s3.Client {
-	// 示例实现，请根据实际需求补充
-	/*
-	   awsCfg, err := awsconfig.LoadDefaultConfig(context.TODO(),
-	       awsconfig.WithRegion(a.cfg.Storage.S3.Region),
-	   )
-	   if err != nil {
-	       log.Printf("Failed to load AWS config: %v", err)
-	       return nil
-	   }
-	   return s3.NewFromConfig(awsCfg)
-	*/
-	return nil
+	client, err := storage.NewS3Client(context.Background(), &a.cfg.Storage.S3)
+	if err != nil {
+		log.Printf("Failed to create S3 client: %v", err)
+		return nil
+	}
+	return client
 }
@@ -2,71 +2,183 @@ package backup
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"database/sql"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"pg-backup/internal/config"
+	"pg-backup/internal/repository"
+	"pg-backup/internal/storage"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/lib/pq"
 )
 
 type Service struct {
 	db       *sql.DB
 	config   *config.Config
 	s3Client *s3.Client
+	storage  *storage.Service
+
+	progressMu sync.Mutex
+	progress   map[*progressEntry]struct{}
+
+	repo   *repository.Repository
+	repoMu sync.Mutex
 }
 
 type BackupRecord struct {
 	ID        int64     `json:"id"`
 	Name      string    `json:"name"`
 	Type      string    `json:"type"`
+	Kind      string    `json:"kind"`
 	Size      string    `json:"size"`
+	SizeBytes int64     `json:"sizeBytes,omitempty"`
 	Status    string    `json:"status"`
 	Timestamp time.Time `json:"timestamp"`
 	Path      string    `json:"path"`
 	Error     string    `json:"error,omitempty"`
+	StartLSN  string    `json:"startLsn,omitempty"`
+	StopLSN   string    `json:"stopLsn,omitempty"`
+	Timeline  int       `json:"timeline,omitempty"`
+
+	// ParentID 增量备份的父备份，Chain 为从完整备份到本备份的 ID 链
+	ParentID int64   `json:"parentId,omitempty"`
+	Chain    []int64 `json:"chain,omitempty"`
+
+	// Selection 逻辑备份的对象范围，为空表示整个数据库
+	Selection *Selection `json:"selection,omitempty"`
+
+	// SourceHost 实际执行备份的主机（host:port），配置了备库时可能是备库
+	SourceHost string `json:"sourceHost,omitempty"`
+
+	// Target 备份的数据库，GroupID 为所属的一致性组
+	Target  string `json:"target,omitempty"`
+	GroupID int64  `json:"groupId,omitempty"`
+
+	// JobID 创建此备份的定时任务，Labels 和 Notes 为用户添加的标签和备注
+	JobID  int64    `json:"jobId,omitempty"`
+	Labels []string `json:"labels"`
+	Notes  string   `json:"notes,omitempty"`
+
+	// Pin 固定信息，固定的备份不受保留策略清理
+	Pin *BackupPin `json:"pin,omitempty"`
 }
 
 func New(db *sql.DB, cfg *config.Config, s3Client *s3.Client) *Service {
+	store := storage.New(&cfg.Storage)
+	store.SetS3Client(s3Client)
+
 	return &Service{
 		db:       db,
 		config:   cfg,
 		s3Client: s3Client,
+		storage:  store,
+		progress: make(map[*progressEntry]struct{}),
+		repo:     repository.New(store, &chunkIndex{db: db}, repositoryPrefix),
 	}
 }
 
 // CreateBackup 创建数据库备份
-func (s *Service) CreateBackup(includeData, includeSchema, compression bool) error {
+func (s *Service) CreateBackup(opts Options) (err error) {
+	if err := s.ValidateOptions(opts); err != nil {
+		return err
+	}
+	if opts.Kind == KindPhysical || opts.Kind == KindIncremental {
+		return s.createPhysicalBackup(opts)
+	}
+
 	timestamp := time.Now()
 	backupName := fmt.Sprintf("backup_%s", timestamp.Format("20060102_150405"))
+	primary := s.primaryConn(opts)
+	if opts.group != 0 {
+		// 同一组的备份同时开始，名称中加入数据库名避免冲突
+		backupName = fmt.Sprintf("backup_%s_%s", fileNameSafe(primary.Database), timestamp.Format("20060102_150405"))
+	}
 
 	// 创建备份记录
-	recordID, err := s.createBackupRecord(backupName, s.config.Storage.Type, "running")
+	recordID, err := s.createBackupRecord(backupName, s.config.Storage.Type, KindLogical, "running", primary.Database, opts)
 	if err != nil {
 		return err
 	}
+	defer func() { s.runFinishHooks(recordID, primary, opts.Hooks, err) }()
+	if opts.group != 0 {
+		if _, err := s.db.Exec("UPDATE backup_records SET group_id = $1 WHERE id = $2", opts.group, recordID); err != nil {
+			s.updateBackupRecord(recordID, "failed", "", "", err.Error())
+			return err
+		}
+	}
+	if err := s.saveSelection(recordID, opts.Selection); err != nil {
+		s.updateBackupRecord(recordID, "failed", "", "", err.Error())
+		return err
+	}
+
+	if err := s.runHooks(recordID, primary, opts.Hooks, HookPre, nil); err != nil {
+		s.updateBackupRecord(recordID, "failed", "", "", err.Error())
+		return err
+	}
+	if err := s.runPreflight(recordID, opts); err != nil {
+		s.updateBackupRecord(recordID, "failed", "", "", err.Error())
+		return err
+	}
+	// 导出的快照只能在主库的同一数据库中导入
+	source, err := s.selectSource(recordID, primary, !opts.pinnedToPrimary())
+	if err != nil {
+		s.updateBackupRecord(recordID, "failed", "", "", err.Error())
+		return err
+	}
+	s.captureTableStats(recordID, source)
+
+	// 按服务器版本选择相同或更新的 pg_dump，没有合适版本时直接失败
+	versions, err := s.selectPgDump(&opts, source)
+	if err != nil {
+		s.updateBackupRecord(recordID, "failed", "", "", err.Error())
+		return err
+	}
+
+	progress := s.trackProgress(recordID, PhaseDumping)
+	defer s.untrackProgress(progress)
+
+	// 仓库模式下由仓库压缩每个块，pg_dump 压缩会破坏去重效果
+	if s.useRepository(opts) {
+		opts.Compression, opts.Compress = false, nil
+	}
+	compression, err := s.compressionConfig(opts)
+	if err != nil {
+		s.updateBackupRecord(recordID, "failed", "", "", err.Error())
+		return err
+	}
+
+	// custom 格式由 pg-backup 在外层压缩，pg_dump 内部不压缩，便于去重和内容校验
+	format := opts.dumpFormat()
+	if format == FormatCustom && compression.Algorithm != CompressionNone {
+		compression.Method = CompressByStream
+	}
 
 	// 构建 pg_dump 命令
-	dumpFile := filepath.Join(os.TempDir(), backupName+".sql")
-	cmd := s.buildPgDumpCommand(includeData, includeSchema, compression, dumpFile)
+	dumpFile := filepath.Join(os.TempDir(), backupName+dumpExt(format)+compressionExt(compression.Algorithm))
+	dumpCfg := s.dumpConfig(opts)
+	s.progressMu.Lock()
+	progress.Nice, progress.IONiceClass = dumpCfg.Nice, dumpCfg.IONiceClass
+	s.progressMu.Unlock()
+	cmd := s.buildPgDumpCommand(opts, source, dumpCfg, compression, dumpFile)
 
 	// 执行备份命令
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		s.updateBackupRecord(recordID, "failed", "", "", stderr.String())
-		return fmt.Errorf("pg_dump failed: %v, stderr: %s", err, stderr.String())
+	stderr, err := s.runDump(cmd, compression, dumpFile)
+	s.runHooks(recordID, primary, opts.Hooks, HookPost, err)
+	if err != nil {
+		os.Remove(dumpFile)
+		s.updateBackupRecord(recordID, "failed", "", "", stderr)
+		return fmt.Errorf("pg_dump failed: %v, stderr: %s", err, stderr)
 	}
 
 	// 获取文件大小
@@ -82,122 +194,347 @@ func (s *Service) CreateBackup(includeData, includeSchema, compression bool) err
 
 	// 内容校验
 	if s.config.Storage.Local.VerifyContent {
-		var content []byte
-		if strings.HasSuffix(dumpFile, ".gz") {
-			file, err := os.Open(dumpFile)
-			if err == nil {
-				defer file.Close()
-				gzr, err := gzip.NewReader(file)
-				if err == nil {
-					defer gzr.Close()
-					content, err = io.ReadAll(gzr)
-				}
-			}
-		} else {
-			content, err = os.ReadFile(dumpFile)
+		s.setPhase(progress, PhaseVerifying, fileInfo.Size())
+		ok, err := verifyDumpContent(dumpFile, compression.Algorithm)
+		if err == nil && !ok {
+			os.Remove(dumpFile)
+			s.updateBackupRecord(recordID, "failed", formatFileSize(fileInfo.Size()), "", "backup file contains no CREATE or INSERT")
+			return fmt.Errorf("backup file content validation failed")
 		}
+	}
 
+	size := formatFileSize(fileInfo.Size())
+	ctx := context.Background()
+	key := s.objectKey(filepath.Base(dumpFile))
+
+	// 上传前从本地文件提取 schema 快照，失败不影响备份，比较时会重新提取
+	var snapshot *SchemaSnapshot
+	if opts.IncludeSchema {
+		pgRestore := "pg_restore"
+		if client, err := s.newestClient(pgRestore, versions.PgDumpMajor); err == nil {
+			pgRestore = client.Path
+		}
+		if snapshot, err = extractSchemaSnapshot(ctx, dumpFile, compression.Algorithm, format, pgRestore); err != nil {
+			log.Printf("Failed to extract schema snapshot of backup %d: %v", recordID, err)
+		}
+	}
+	var (
+		finalPath string
+		manifest  *Manifest
+	)
+
+	// 根据备份类型处理文件：仓库模式切块去重，超过分卷大小时按卷存储
+	if s.useRepository(opts) {
+		stats := s.setPhase(progress, PhaseUploading, fileInfo.Size())
+		manifest, err = s.storeInRepository(ctx, recordID, dumpFile, backupName, stats)
 		if err == nil {
-			if !bytes.Contains(content, []byte("CREATE")) && !bytes.Contains(content, []byte("INSERT")) {
-				s.updateBackupRecord(recordID, "failed", formatFileSize(fileInfo.Size()), "", "backup file contains no CREATE or INSERT")
-				return fmt.Errorf("backup file content validation failed")
+			finalPath = s.objectPath(manifest.Key)
+			if s.config.Storage.Local.Retention > 0 {
+				go s.expireRepositoryBackups()
+			}
+		}
+	} else if volumeSize := s.volumeSize(opts); volumeSize > 0 && fileInfo.Size() > volumeSize {
+		stats := s.setPhase(progress, PhaseUploading, fileInfo.Size())
+		manifest, err = s.storeVolumes(ctx, dumpFile, key, volumeSize, storage.TransferOptions{
+			RateLimit: opts.UploadLimit,
+			Stats:     stats,
+		})
+		if err == nil {
+			finalPath = s.objectPath(key)
+			if s.config.Storage.Type == "local" && s.config.Storage.Local.Retention > 0 {
+				go s.cleanupOldBackups()
+			}
+		}
+	} else {
+		manifest = &Manifest{Key: key, Size: fileInfo.Size()}
+		manifest.SHA256, err = fileChecksum(dumpFile)
+		if err == nil {
+			switch s.config.Storage.Type {
+			case "local":
+				finalPath, err = s.handleLocalBackup(dumpFile, backupName)
+			case "s3":
+				stats := s.setPhase(progress, PhaseUploading, fileInfo.Size())
+				finalPath, err = s.handleS3Backup(ctx, dumpFile, storage.TransferOptions{
+					RateLimit: opts.UploadLimit,
+					Stats:     stats,
+				})
 			}
 		}
-	}
-
-	size := formatFileSize(fileInfo.Size())
-	var finalPath string
-
-	// 根据备份类型处理文件
-	switch s.config.Storage.Type {
-	case "local":
-		finalPath, err = s.handleLocalBackup(dumpFile, backupName)
-	case "s3":
-		finalPath, err = s.handleS3Backup(context.Background(), dumpFile, backupName)
 	}
 
 	// 清理临时文件
 	os.Remove(dumpFile)
 
+	if err == nil {
+		manifest.Version = manifestVersion
+		manifest.Name = backupName
+		manifest.CreatedAt = timestamp
+		manifest.Compression = &compression
+		manifest.Format = format
+		manifest.DataOnly = !opts.IncludeSchema
+		manifest.Versions = versions
+		err = s.saveManifest(ctx, recordID, manifest)
+	}
+
 	if err != nil {
 		s.updateBackupRecord(recordID, "failed", size, "", err.Error())
 		return err
 	}
 
+	if snapshot != nil {
+		if err := s.saveSchemaSnapshot(ctx, recordID, snapshot); err != nil {
+			log.Printf("Failed to save schema snapshot of backup %d: %v", recordID, err)
+		}
+	}
+
 	// 更新备份记录为成功
 	s.updateBackupRecord(recordID, "completed", size, finalPath, "")
 	return nil
 }
 
-// GetBackupHistory 获取备份历史记录
-func (s *Service) GetBackupHistory() ([]BackupRecord, error) {
-	rows, err := s.db.Query(`
-		SELECT id, name, type, COALESCE(size, ''), status, timestamp, COALESCE(path, ''), COALESCE(error, '')
-		FROM backup_records 
-		ORDER BY timestamp DESC 
-		LIMIT 100
-	`)
+// DeleteBackup 删除备份，仓库模式的备份会同时删除索引并回收不再引用的块，
+// 固定的备份和仍有增量备份依赖的物理备份不允许删除
+func (s *Service) DeleteBackup(id int64) error {
+	ctx := context.Background()
+	manifest, err := s.loadManifest(ctx, id)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var records []BackupRecord
-	for rows.Next() {
-		var record BackupRecord
-		err := rows.Scan(&record.ID, &record.Name, &record.Type, &record.Size,
-			&record.Status, &record.Timestamp, &record.Path, &record.Error)
+	pinned, err := s.isPinned(ctx, id)
+	if err != nil {
+		return err
+	}
+	if pinned {
+		return fmt.Errorf("backup %d is pinned, unpin it before deleting", id)
+	}
+
+	children, err := s.countChildBackups(ctx, id)
+	if err != nil {
+		return err
+	}
+	if children > 0 {
+		return fmt.Errorf("backup %d has %d dependent incremental backups", id, children)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM backup_records WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	if manifest != nil && manifest.Repository != nil {
+		if err := s.repo.DeleteIndex(ctx, manifest.Repository.Index); err != nil {
+			return err
+		}
+		return s.pruneRepository(ctx)
+	}
+	if manifest != nil {
+		s.deleteBackupFiles(ctx, manifest)
+	}
+	return nil
+}
+
+// DownloadBackup 以流的方式下载备份文件，rateLimit 覆盖配置中的下载限速（0 使用配置值，负数不限速），
+// decompress 为 true 时按清单记录的算法解压后返回
+func (s *Service) DownloadBackup(ctx context.Context, id int64, rateLimit int64, decompress bool) (io.ReadCloser, string, error) {
+	var backupType, path, status string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT type, COALESCE(path, ''), status FROM backup_records WHERE id = $1
+	`, id).Scan(&backupType, &path, &status)
+	if err != nil {
+		return nil, "", err
+	}
+	if status != "completed" || path == "" {
+		return nil, "", fmt.Errorf("backup %d is not available for download (status: %s)", id, status)
+	}
+	if backupType != s.config.Storage.Type {
+		return nil, "", fmt.Errorf("backup %d is stored in %s, but current storage is %s", id, backupType, s.config.Storage.Type)
+	}
+
+	manifest, err := s.loadManifest(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	progress := s.trackProgress(id, PhaseDownloading)
+	transfer := storage.TransferOptions{RateLimit: rateLimit}
+	rc, key, err := s.openBackupStream(ctx, backupType, path, manifest, func(total int64) storage.TransferOptions {
+		transfer.Stats = s.setPhase(progress, PhaseDownloading, total)
+		return transfer
+	})
+	if err != nil {
+		s.untrackProgress(progress)
+		return nil, "", err
+	}
+	filename := filepath.Base(key)
+
+	if decompress && manifest != nil && manifest.Compression != nil {
+		dec, err := newDecompressor(rc, manifest.Compression.Algorithm)
 		if err != nil {
-			continue
+			rc.Close()
+			s.untrackProgress(progress)
+			return nil, "", err
 		}
-		records = append(records, record)
+		rc = &stackedReadCloser{Reader: dec, closers: []io.Closer{dec, rc}}
+		filename = strings.TrimSuffix(filename, compressionExt(manifest.Compression.Algorithm))
 	}
 
-	return records, nil
+	return &trackedReadCloser{ReadCloser: rc, done: func() { s.untrackProgress(progress) }}, filename, nil
 }
 
-// DeleteBackup 删除备份
-func (s *Service) DeleteBackup(id int64) error {
-	_, err := s.db.Exec("DELETE FROM backup_records WHERE id = $1", id)
-	return err
+// openBackupStream 打开备份数据流，分卷备份会被透明地重新拼接
+func (s *Service) openBackupStream(ctx context.Context, backupType, path string, manifest *Manifest, transfer func(total int64) storage.TransferOptions) (io.ReadCloser, string, error) {
+	if manifest != nil && manifest.Repository != nil {
+		transfer(manifest.Size)
+		rc, err := s.openRepository(ctx, manifest.Repository)
+		return rc, manifest.Name + dumpExt(manifest.Format), err
+	}
+	if manifest != nil && len(manifest.Volumes) > 0 {
+		return s.openVolumes(ctx, manifest.Volumes, transfer(manifest.Size)), manifest.Key, nil
+	}
+
+	key, err := s.storageKey(backupType, path)
+	if err != nil {
+		return nil, "", err
+	}
+	total := int64(-1)
+	if manifest != nil {
+		total = manifest.Size
+	}
+	rc, err := s.storage.Download(ctx, key, transfer(total))
+	return rc, key, err
+}
+
+// stackedReadCloser 关闭时依次关闭多层 Reader
+type stackedReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *stackedReadCloser) Close() error {
+	var first error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// trackedReadCloser 在关闭时结束进度跟踪
+type trackedReadCloser struct {
+	io.ReadCloser
+	done func()
+}
+
+func (t *trackedReadCloser) Close() error {
+	t.done()
+	return t.ReadCloser.Close()
+}
+
+// storageKey 将备份记录中的路径转换为存储层的 key
+func (s *Service) storageKey(backupType, path string) (string, error) {
+	switch backupType {
+	case "local":
+		return filepath.Rel(s.config.Storage.Local.BackupPath, path)
+	case "s3":
+		prefix := fmt.Sprintf("s3://%s/", s.config.Storage.S3.Bucket)
+		if !strings.HasPrefix(path, prefix) {
+			return "", fmt.Errorf("backup path %s is not in bucket %s", path, s.config.Storage.S3.Bucket)
+		}
+		return strings.TrimPrefix(path, prefix), nil
+	default:
+		return "", fmt.Errorf("unsupported storage type: %s", backupType)
+	}
+}
+
+// objectKey 返回备份文件在存储中的 key
+func (s *Service) objectKey(filename string) string {
+	if s.config.Storage.Type == "s3" {
+		return "postgresql-backups/" + filename
+	}
+	return filename
 }
 
-// DownloadBackup 下载备份文件
-func (s *Service) DownloadBackup(id int64) ([]byte, error) {
-	// TODO: 实现下载逻辑
-	return nil, nil
+// objectPath 返回记录在备份记录中的路径，是 storageKey 的逆操作
+func (s *Service) objectPath(key string) string {
+	if s.config.Storage.Type == "s3" {
+		return fmt.Sprintf("s3://%s/%s", s.config.Storage.S3.Bucket, key)
+	}
+	return filepath.Join(s.config.Storage.Local.BackupPath, key)
 }
 
 // 内部辅助方法
-func (s *Service) buildPgDumpCommand(includeData, includeSchema, compression bool, outputFile string) *exec.Cmd {
+func (s *Service) buildPgDumpCommand(opts Options, source config.DatabaseConfig, dumpCfg config.DumpConfig, compression config.CompressionConfig, outputFile string) *exec.Cmd {
 	args := []string{
-		"-h", s.config.Database.Host,
-		"-p", strconv.Itoa(s.config.Database.Port),
-		"-U", s.config.Database.Username,
-		"-d", s.config.Database.Database,
-		"-f", outputFile,
+		"-h", source.Host,
+		"-p", strconv.Itoa(source.Port),
+		"-U", source.Username,
+		"-d", source.Database,
 		"--verbose",
 	}
 
-	if !includeData {
+	// Validate 已保证两者不会同时为 false
+	if !opts.IncludeData {
 		args = append(args, "--schema-only")
 	}
-	if !includeSchema {
+	if !opts.IncludeSchema {
 		args = append(args, "--data-only")
 	}
-	if compression {
-		args = append(args, "--compress=6")
-		// 如果使用压缩，修改输出文件扩展名
-		outputFile = strings.Replace(outputFile, ".sql", ".sql.gz", 1)
-		args[len(args)-3] = outputFile // 更新 -f 参数
+	args = append(args, opts.Selection.args()...)
+	if opts.snapshot != "" {
+		args = append(args, "--snapshot="+opts.snapshot)
+	}
+	if opts.dumpFormat() == FormatCustom {
+		args = append(args, "--format=custom", "--compress=0")
+	}
+	switch compression.Method {
+	case CompressByPgDump:
+		args = append(args, "-f", outputFile, pgDumpCompressArg(compression))
+	case CompressByStream:
+		// 输出到标准输出，由 runDump 压缩后写入文件
+	default:
+		args = append(args, "-f", outputFile)
 	}
 
-	cmd := exec.Command("pg_dump", args...)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.Database.Password))
+	name, args := withProcessPriority(dumpCfg, opts.pgDumpName(), args)
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", source.Password))
 
 	return cmd
 }
 
+// runDump 执行 pg_dump，流式压缩时将标准输出经压缩后写入 outputFile，返回 stderr 内容
+func (s *Service) runDump(cmd *exec.Cmd, compression config.CompressionConfig, outputFile string) (string, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if compression.Method != CompressByStream {
+		err := cmd.Run()
+		return stderr.String(), err
+	}
+
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	zw, err := newCompressor(file, compression)
+	if err != nil {
+		return "", err
+	}
+	cmd.Stdout = zw
+
+	if err := cmd.Run(); err != nil {
+		zw.Close()
+		return stderr.String(), err
+	}
+	if err := zw.Close(); err != nil {
+		return stderr.String(), err
+	}
+	return stderr.String(), file.Close()
+}
+
 func (s *Service) handleLocalBackup(sourceFile, backupName string) (string, error) {
 	// 确保备份目录存在
 	if err := os.MkdirAll(s.config.Storage.Local.BackupPath, 0755); err != nil {
@@ -219,7 +556,7 @@ func (s *Service) handleLocalBackup(sourceFile, backupName string) (string, erro
 	return finalPath, nil
 }
 
-func (s *Service) handleS3Backup(ctx context.Context, sourceFile, backupName string) (string, error) {
+func (s *Service) handleS3Backup(ctx context.Context, sourceFile string, transfer storage.TransferOptions) (string, error) {
 	if s.s3Client == nil {
 		return "", fmt.Errorf("S3 client not configured")
 	}
@@ -230,28 +567,34 @@ func (s *Service) handleS3Backup(ctx context.Context, sourceFile, backupName str
 	}
 	defer file.Close()
 
-	key := fmt.Sprintf("postgresql-backups/%s", filepath.Base(sourceFile))
+	key := s.objectKey(filepath.Base(sourceFile))
 
-	_, err = s.s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(s.config.Storage.S3.Bucket),
-		Key:    aws.String(key),
-		Body:   file,
-	})
-
-	if err != nil {
+	if err := s.storage.Upload(ctx, key, file, -1, transfer); err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("s3://%s/%s", s.config.Storage.S3.Bucket, key), nil
+	return s.objectPath(key), nil
 }
 
-func (s *Service) createBackupRecord(name, backupType, status string) (int64, error) {
+func (s *Service) createBackupRecord(name, backupType, kind, status, target string, opts Options) (int64, error) {
+	labels, err := normalizeLabels(opts.Labels)
+	if err != nil {
+		return 0, err
+	}
+	var jobID interface{}
+	if opts.JobID != 0 {
+		jobID = opts.JobID
+	}
+
 	var id int64
-	err := s.db.QueryRow(`
-		INSERT INTO backup_records (name, type, status)
-		VALUES ($1, $2, $3)
+	err = s.db.QueryRow(`
+		INSERT INTO backup_records (name, type, kind, status, target, labels, notes, job_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id
-	`, name, backupType, status).Scan(&id)
+	`, name, backupType, kind, status, target, pq.Array(labels), opts.Notes, jobID).Scan(&id)
+	if err == nil && opts.OnRecord != nil {
+		opts.OnRecord(id)
+	}
 	return id, err
 }
 
@@ -263,16 +606,37 @@ func (s *Service) updateBackupRecord(id int64, status, size, path, errorMsg stri
 	`, status, size, path, errorMsg, id)
 }
 
+// cleanupOldBackups 按保留天数删除本地备份目录中的旧备份文件，跳过固定的备份
 func (s *Service) cleanupOldBackups() {
+	pinned, err := s.pinnedBackupNames(context.Background())
+	if err != nil {
+		// 无法确认哪些备份被固定时不清理
+		log.Printf("Failed to query pinned backups, skipping cleanup: %v", err)
+		return
+	}
+
 	cutoff := time.Now().AddDate(0, 0, -s.config.Storage.Local.Retention)
-	pattern := filepath.Join(s.config.Storage.Local.BackupPath, "backup_*.sql*")
+	for _, pattern := range []string{"backup_*.sql*", "backup_*.dump*"} {
+		matches, _ := filepath.Glob(filepath.Join(s.config.Storage.Local.BackupPath, pattern))
+		for _, file := range matches {
+			if isPinnedFile(filepath.Base(file), pinned) {
+				continue
+			}
+			if info, err := os.Stat(file); err == nil && info.ModTime().Before(cutoff) {
+				os.Remove(file)
+			}
+		}
+	}
+}
 
-	matches, _ := filepath.Glob(pattern)
-	for _, file := range matches {
-		if info, err := os.Stat(file); err == nil && info.ModTime().Before(cutoff) {
-			os.Remove(file)
+// isPinnedFile 判断文件是否属于固定的备份（文件名为备份名加扩展名或分卷后缀）
+func isPinnedFile(base string, pinned []string) bool {
+	for _, name := range pinned {
+		if strings.HasPrefix(base, name+".") {
+			return true
 		}
 	}
+	return false
 }
 
 func formatFileSize(bytes int64) string {
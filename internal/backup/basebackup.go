@@ -0,0 +1,434 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"pg-backup/internal/config"
+	"pg-backup/internal/storage"
+)
+
+// 备份种类
+const (
+	KindLogical     = "logical"     // pg_dump 逻辑备份
+	KindPhysical    = "physical"    // pg_basebackup 物理备份
+	KindIncremental = "incremental" // 基于父备份 backup_manifest 的增量物理备份（PostgreSQL 17+）
+)
+
+// PhysicalOptions pg_basebackup 的参数
+type PhysicalOptions struct {
+	// WALMethod WAL 的获取方式：fetch（默认，备份结束后获取）、stream（并行流式获取）、none。
+	// fetch 和 none 在没有其他表空间时直接流式上传；stream 需要先在临时目录中生成完整的 tar 文件。
+	WALMethod string `json:"walMethod"`
+	// Checkpoint 检查点方式：fast 或 spread
+	Checkpoint string `json:"checkpoint"`
+	// ParentID 增量备份的父备份，为 0 时使用最近一次完成的物理或增量备份
+	ParentID int64 `json:"parentId,omitempty"`
+}
+
+// BackupFile 物理备份中的单个 tar 文件
+type BackupFile struct {
+	Name   string `json:"name"`
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// PhysicalInfo 物理备份的 WAL 位置信息
+type PhysicalInfo struct {
+	StartLSN   string `json:"startLsn"`
+	StopLSN    string `json:"stopLsn"`
+	Timeline   int    `json:"timeline"`
+	WALMethod  string `json:"walMethod"`
+	Checkpoint string `json:"checkpoint"`
+	// ParentID 和 ParentManifest 记录增量备份所基于的父备份及其 backup_manifest
+	ParentID       int64  `json:"parentId,omitempty"`
+	ParentManifest string `json:"parentManifest,omitempty"`
+}
+
+var (
+	walStartPattern = regexp.MustCompile(`write-ahead log start point: ([0-9A-Fa-f]+/[0-9A-Fa-f]+) on timeline (\d+)`)
+	walEndPattern   = regexp.MustCompile(`write-ahead log end point: ([0-9A-Fa-f]+/[0-9A-Fa-f]+)`)
+)
+
+// validatePhysicalOptions 校验并填充默认值
+func validatePhysicalOptions(opts *PhysicalOptions) error {
+	if opts.WALMethod == "" {
+		opts.WALMethod = "fetch"
+	}
+	if opts.Checkpoint == "" {
+		opts.Checkpoint = "fast"
+	}
+	switch opts.WALMethod {
+	case "fetch", "stream", "none":
+	default:
+		return fmt.Errorf("invalid WAL method: %s", opts.WALMethod)
+	}
+	switch opts.Checkpoint {
+	case "fast", "spread":
+	default:
+		return fmt.Errorf("invalid checkpoint mode: %s", opts.Checkpoint)
+	}
+	return nil
+}
+
+// createPhysicalBackup 使用 pg_basebackup 以 tar 格式备份整个集群并上传，能输出到标准输出时直接流式上传，
+// 否则先写入临时目录。增量备份时基于父备份的 backup_manifest 只备份变化的块
+func (s *Service) createPhysicalBackup(opts Options) (err error) {
+	kind, prefixName := KindPhysical, "basebackup"
+	if opts.Kind == KindIncremental {
+		kind, prefixName = KindIncremental, "incremental"
+	}
+	timestamp := time.Now()
+	backupName := fmt.Sprintf("%s_%s", prefixName, timestamp.Format("20060102_150405"))
+
+	primary := s.primaryConn(opts)
+	recordID, err := s.createBackupRecord(backupName, s.config.Storage.Type, kind, "running", primary.Database, opts)
+	if err != nil {
+		return err
+	}
+	defer func() { s.runFinishHooks(recordID, primary, opts.Hooks, err) }()
+
+	fail := func(size string, err error) error {
+		s.updateBackupRecord(recordID, "failed", size, "", err.Error())
+		return err
+	}
+
+	physical := PhysicalOptions{}
+	if opts.Physical != nil {
+		physical = *opts.Physical
+	}
+	if err := validatePhysicalOptions(&physical); err != nil {
+		return fail("", err)
+	}
+
+	// tar 由 pg-backup 流式压缩后上传
+	compression, err := s.compressionConfig(opts)
+	if err != nil {
+		return fail("", err)
+	}
+	if compression.Algorithm != CompressionNone {
+		compression.Method = CompressByStream
+	}
+
+	if err := s.runHooks(recordID, primary, opts.Hooks, HookPre, nil); err != nil {
+		return fail("", err)
+	}
+	if err := s.runPreflight(recordID, opts); err != nil {
+		return fail("", err)
+	}
+	source, err := s.selectSource(recordID, primary, true)
+	if err != nil {
+		return fail("", err)
+	}
+	s.captureTableStats(recordID, source)
+
+	progress := s.trackProgress(recordID, PhaseDumping)
+	defer s.untrackProgress(progress)
+
+	workDir, err := os.MkdirTemp("", backupName+"-")
+	if err != nil {
+		return fail("", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	ctx := context.Background()
+	var parent *parentBackup
+	if kind == KindIncremental {
+		parent, err = s.prepareIncremental(ctx, physical.ParentID, filepath.Join(workDir, "parent_manifest"))
+		if err != nil {
+			return fail("", err)
+		}
+	}
+
+	prefix := s.objectKey(backupName)
+	transfer := storage.TransferOptions{RateLimit: opts.UploadLimit}
+	cluster, err := inspectCluster(source)
+	if err != nil {
+		return fail("", err)
+	}
+
+	var files []BackupFile
+	var total int64
+	var output string
+	if cluster.streamable(physical) {
+		transfer.Stats = s.setPhase(progress, PhaseUploading, cluster.size)
+		files, total, output, err = s.streamBasebackup(ctx, physical, source, opts, parent, workDir, prefix, compression, transfer)
+	} else {
+		files, total, output, err = s.spoolBasebackup(ctx, physical, source, opts, parent, workDir, prefix, compression, transfer, cluster, progress)
+	}
+	s.runHooks(recordID, primary, opts.Hooks, HookPost, err)
+	if err != nil {
+		return fail(formatFileSize(total), err)
+	}
+
+	info, err := parseBasebackupOutput(output)
+	if err != nil {
+		return fail("", err)
+	}
+	info.WALMethod, info.Checkpoint = physical.WALMethod, physical.Checkpoint
+	var parentID sql.NullInt64
+	if parent != nil {
+		info.ParentID, info.ParentManifest = parent.id, parent.manifest.Key
+		parentID = sql.NullInt64{Int64: parent.id, Valid: true}
+	}
+
+	manifest := &Manifest{
+		Version:     manifestVersion,
+		Name:        backupName,
+		Key:         prefix,
+		CreatedAt:   timestamp,
+		Size:        total,
+		Compression: &compression,
+		Physical:    info,
+		Files:       files,
+	}
+	if err := s.saveManifest(ctx, recordID, manifest); err != nil {
+		return fail(formatFileSize(total), err)
+	}
+
+	s.db.ExecContext(ctx, `
+		UPDATE backup_records
+		SET start_lsn = $1, stop_lsn = $2, timeline = $3, parent_id = $4, completed_at = CURRENT_TIMESTAMP
+		WHERE id = $5
+	`, info.StartLSN, info.StopLSN, info.Timeline, parentID, recordID)
+	s.updateBackupRecord(recordID, "completed", formatFileSize(total), s.objectPath(prefix), "")
+
+	if s.config.Storage.Local.Retention > 0 {
+		go s.expirePhysicalBackups()
+	}
+	return nil
+}
+
+// clusterLayout 决定 pg_basebackup 能否输出到标准输出
+type clusterLayout struct {
+	tablespaces int   // pg_default、pg_global 以外的表空间数
+	size        int64 // 所有数据库的大小之和
+}
+
+// streamable pg_basebackup 只有在没有其他表空间且不并行流式获取 WAL 时才能输出到标准输出
+func (c clusterLayout) streamable(physical PhysicalOptions) bool {
+	return c.tablespaces == 0 && physical.WALMethod != "stream"
+}
+
+// inspectCluster 查询备份来源的表空间数量和集群大小
+func inspectCluster(source config.DatabaseConfig) (clusterLayout, error) {
+	var layout clusterLayout
+	db, err := openDatabase(source)
+	if err != nil {
+		return layout, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = db.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM pg_tablespace WHERE spcname NOT IN ('pg_default', 'pg_global')),
+		       (SELECT COALESCE(SUM(pg_database_size(oid)), 0)::bigint FROM pg_database)
+	`).Scan(&layout.tablespaces, &layout.size)
+	if err != nil {
+		return layout, fmt.Errorf("failed to inspect cluster: %w", err)
+	}
+	return layout, nil
+}
+
+// streamBasebackup 将 pg_basebackup -D - 输出的 tar 直接压缩上传为 base.tar，不在本地落盘。
+// 只适用于没有其他表空间、WAL 方式为 fetch 或 none 的集群。
+// tar 中的 backup_manifest 同时单独上传，供后续增量备份使用。
+func (s *Service) streamBasebackup(ctx context.Context, physical PhysicalOptions, source config.DatabaseConfig, opts Options,
+	parent *parentBackup, workDir, prefix string, compression config.CompressionConfig, transfer storage.TransferOptions) ([]BackupFile, int64, string, error) {
+	cmd := s.buildPgBasebackupCommand(physical, source, s.dumpConfig(opts), "-", parent)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, 0, "", err
+	}
+
+	// 边上传边从 tar 流中取出 backup_manifest
+	manifestPath := filepath.Join(workDir, "backup_manifest")
+	pr, pw := io.Pipe()
+	found := make(chan error, 1)
+	go func() {
+		found <- extractTarMember(pr, "backup_manifest", manifestPath)
+		io.Copy(io.Discard, pr)
+	}()
+
+	counter := &countingWriter{}
+	base, err := s.uploadStream(ctx, io.TeeReader(stdout, io.MultiWriter(pw, counter)), "base.tar", path.Join(prefix, "base.tar"), compression, transfer)
+	if err != nil {
+		cmd.Process.Kill()
+	}
+	pw.Close()
+	ferr := <-found
+	werr := cmd.Wait()
+	if werr != nil {
+		return nil, counter.n, stderr.String(), fmt.Errorf("pg_basebackup failed: %v, stderr: %s", werr, stderr.String())
+	}
+	if err != nil {
+		return nil, counter.n, stderr.String(), fmt.Errorf("failed to upload base.tar: %w", err)
+	}
+	if ferr != nil {
+		return nil, counter.n, stderr.String(), ferr
+	}
+
+	files := []BackupFile{*base}
+	mf, err := s.uploadBackupFile(ctx, manifestPath, path.Join(prefix, "backup_manifest"), compression, storage.TransferOptions{RateLimit: transfer.RateLimit})
+	if err != nil {
+		return nil, counter.n, stderr.String(), fmt.Errorf("failed to upload backup_manifest: %w", err)
+	}
+	return append(files, *mf), counter.n, stderr.String(), nil
+}
+
+// spoolBasebackup 在临时目录中生成各个 tar 文件后逐个上传，用于 WAL 流式获取或有其他表空间的集群。
+// 开始前确认临时目录能容纳整个集群，预检被跳过时也不会在备份中途写满磁盘。
+func (s *Service) spoolBasebackup(ctx context.Context, physical PhysicalOptions, source config.DatabaseConfig, opts Options,
+	parent *parentBackup, workDir, prefix string, compression config.CompressionConfig, transfer storage.TransferOptions,
+	cluster clusterLayout, progress *progressEntry) ([]BackupFile, int64, string, error) {
+	if free, err := freeSpace(workDir); err == nil && free < cluster.size {
+		return nil, 0, "", fmt.Errorf("%s free in %s, cluster is %s; use walMethod fetch or none to stream the backup instead",
+			formatFileSize(free), workDir, formatFileSize(cluster.size))
+	}
+
+	outputDir := filepath.Join(workDir, "data")
+	cmd := s.buildPgBasebackupCommand(physical, source, s.dumpConfig(opts), outputDir, parent)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, 0, stderr.String(), fmt.Errorf("pg_basebackup failed: %v, stderr: %s", err, stderr.String())
+	}
+
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return nil, 0, stderr.String(), err
+	}
+	var total int64
+	var names []string
+	for _, entry := range entries {
+		if fi, err := entry.Info(); err == nil && !entry.IsDir() {
+			total += fi.Size()
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	transfer.Stats = s.setPhase(progress, PhaseUploading, total)
+	var files []BackupFile
+	for _, name := range names {
+		file, err := s.uploadBackupFile(ctx, filepath.Join(outputDir, name), path.Join(prefix, name), compression, transfer)
+		if err != nil {
+			return nil, total, stderr.String(), fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+		files = append(files, *file)
+	}
+	return files, total, stderr.String(), nil
+}
+
+// buildPgBasebackupCommand 构建 pg_basebackup 命令，outputDir 为 "-" 时 tar 输出到标准输出
+func (s *Service) buildPgBasebackupCommand(physical PhysicalOptions, source config.DatabaseConfig, dumpCfg config.DumpConfig, outputDir string, parent *parentBackup) *exec.Cmd {
+	args := []string{
+		"-h", source.Host,
+		"-p", strconv.Itoa(source.Port),
+		"-U", source.Username,
+		"-D", outputDir,
+		"-F", "tar",
+		"-X", physical.WALMethod,
+		"-c", physical.Checkpoint,
+		"--verbose",
+	}
+	if parent != nil {
+		args = append(args, "--incremental", parent.localPath)
+	}
+
+	name, args := withProcessPriority(dumpCfg, "pg_basebackup", args)
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", source.Password))
+	return cmd
+}
+
+// parseBasebackupOutput 从 pg_basebackup --verbose 的输出中解析起止 LSN 和时间线
+func parseBasebackupOutput(output string) (*PhysicalInfo, error) {
+	start := walStartPattern.FindStringSubmatch(output)
+	if start == nil {
+		return nil, fmt.Errorf("could not find WAL start point in pg_basebackup output")
+	}
+	end := walEndPattern.FindStringSubmatch(output)
+	if end == nil {
+		return nil, fmt.Errorf("could not find WAL end point in pg_basebackup output")
+	}
+
+	timeline, err := strconv.Atoi(start[2])
+	if err != nil {
+		return nil, err
+	}
+	return &PhysicalInfo{StartLSN: start[1], StopLSN: end[1], Timeline: timeline}, nil
+}
+
+// uploadBackupFile 压缩并上传单个文件，同时计算压缩后数据的校验值
+func (s *Service) uploadBackupFile(ctx context.Context, localPath, key string, compression config.CompressionConfig, transfer storage.TransferOptions) (*BackupFile, error) {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return s.uploadStream(ctx, src, filepath.Base(localPath), key, compression, transfer)
+}
+
+// uploadStream 压缩并上传数据流，name 为清单中记录的文件名
+func (s *Service) uploadStream(ctx context.Context, src io.Reader, name, key string, compression config.CompressionConfig, transfer storage.TransferOptions) (*BackupFile, error) {
+	key += compressionExt(compression.Algorithm)
+	hash := sha256.New()
+	counter := &countingWriter{}
+
+	pr, pw := io.Pipe()
+	go func() {
+		zw, err := newCompressor(pw, compression)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(zw, src); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(zw.Close())
+	}()
+
+	body := io.TeeReader(pr, io.MultiWriter(hash, counter))
+	if err := s.storage.Upload(ctx, key, body, -1, transfer); err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+
+	return &BackupFile{
+		Name:   name,
+		Key:    key,
+		Size:   counter.n,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
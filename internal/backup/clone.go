@@ -0,0 +1,289 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"pg-backup/internal/config"
+)
+
+const (
+	defaultCloneTTL     = 72 * time.Hour
+	cloneReaperInterval = time.Minute
+)
+
+// Target 被备份的数据库，ID 为数据库名
+type Target struct {
+	ID           string     `json:"id"`
+	Backups      int        `json:"backups"`
+	LastBackupAt *time.Time `json:"lastBackupAt,omitempty"`
+}
+
+// CloneRequest 克隆参数
+type CloneRequest struct {
+	Database string `json:"database" binding:"required"` // 新建的数据库名
+	Server   string `json:"server"`                      // 配置中 servers 的名称，为空时为备份来源服务器
+	// BackupID 指定备份，为 0 时使用目标最近一次通过校验的逻辑备份
+	BackupID       int64  `json:"backupId"`
+	MaskingProfile string `json:"maskingProfile"`
+	// TTL 克隆的存活时间（如 "72h"），到期后自动删除，为空时为 72 小时
+	TTL string `json:"ttl"`
+}
+
+// Clone 克隆出的数据库
+type Clone struct {
+	ID             int64      `json:"id"`
+	Target         string     `json:"target"`
+	BackupID       int64      `json:"backupId"`
+	Server         string     `json:"server,omitempty"`
+	Database       string     `json:"database"`
+	MaskingProfile string     `json:"maskingProfile,omitempty"`
+	Status         string     `json:"status"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	DroppedAt      *time.Time `json:"droppedAt,omitempty"`
+}
+
+// GetTargets 列出有备份记录的目标，旧记录归入配置中的数据库
+func (s *Service) GetTargets(ctx context.Context) ([]Target, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT COALESCE(target, $1) AS t, COUNT(*), MAX(timestamp)
+		FROM backup_records
+		GROUP BY t
+		ORDER BY t
+	`, s.config.Database.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []Target
+	for rows.Next() {
+		var t Target
+		var last sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Backups, &last); err != nil {
+			return nil, err
+		}
+		if last.Valid {
+			t.LastBackupAt = &last.Time
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// latestVerifiedBackup 返回目标最近一次完成且清单带校验和的逻辑备份，克隆前按校验和验证备份数据
+func (s *Service) latestVerifiedBackup(ctx context.Context, target string) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id FROM backup_records
+		WHERE COALESCE(target, $1) = $2 AND kind = $3 AND status = 'completed'
+		  AND COALESCE(manifest->>'sha256', '') <> '' AND type = $4
+		ORDER BY timestamp DESC
+		LIMIT 1
+	`, s.config.Database.Database, target, KindLogical, s.config.Storage.Type).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no verified logical backup found for target %s", target)
+	}
+	return id, err
+}
+
+// CloneTarget 校验参数后在后台将目标的备份恢复到新数据库，返回克隆记录
+func (s *Service) CloneTarget(ctx context.Context, target string, req CloneRequest) (*Clone, error) {
+	ttl := defaultCloneTTL
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid ttl: %s", req.TTL)
+		}
+	}
+
+	conn, err := s.serverConn(req.Server, req.Database)
+	if err != nil {
+		return nil, err
+	}
+	if s.isProduction(req.Server, conn) {
+		return nil, fmt.Errorf("cannot clone into production database %s", req.Database)
+	}
+	if req.MaskingProfile != "" {
+		if _, err := s.loadMaskingProfile(ctx, req.MaskingProfile); err != nil {
+			return nil, err
+		}
+	}
+
+	backupID := req.BackupID
+	if backupID == 0 {
+		if backupID, err = s.latestVerifiedBackup(ctx, target); err != nil {
+			return nil, err
+		}
+	}
+
+	clone := &Clone{
+		Target:         target,
+		BackupID:       backupID,
+		Server:         req.Server,
+		Database:       req.Database,
+		MaskingProfile: req.MaskingProfile,
+		Status:         "creating",
+	}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO clones (target, backup_id, server, database, masking_profile, status, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP + $7 * INTERVAL '1 second')
+		RETURNING id, created_at, expires_at
+	`, target, backupID, req.Server, req.Database, req.MaskingProfile, clone.Status, int64(ttl.Seconds())).
+		Scan(&clone.ID, &clone.CreatedAt, &clone.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	go s.runClone(clone, conn)
+	return clone, nil
+}
+
+// runClone 校验备份后创建数据库并恢复，失败时删除已创建的数据库
+func (s *Service) runClone(clone *Clone, conn config.DatabaseConfig) {
+	ctx := context.Background()
+	err := s.verifyBackup(ctx, clone.BackupID)
+	if err == nil {
+		err = s.createDatabase(ctx, conn)
+	} else {
+		err = fmt.Errorf("backup %d failed verification: %w", clone.BackupID, err)
+	}
+	if err == nil {
+		err = s.Restore(ctx, clone.BackupID, RestoreOptions{
+			Server:         clone.Server,
+			Database:       clone.Database,
+			MaskingProfile: clone.MaskingProfile,
+		})
+		if err != nil {
+			if derr := s.dropDatabase(ctx, conn); derr != nil {
+				log.Printf("Failed to drop incomplete clone %s: %v", conn.Database, derr)
+			}
+		}
+	}
+
+	if err != nil {
+		log.Printf("Clone %d failed: %v", clone.ID, err)
+		s.db.ExecContext(ctx, "UPDATE clones SET status = 'failed', error = $1 WHERE id = $2", err.Error(), clone.ID)
+		return
+	}
+	s.db.ExecContext(ctx, "UPDATE clones SET status = 'ready' WHERE id = $1", clone.ID)
+}
+
+// GetClones 列出克隆记录
+func (s *Service) GetClones(ctx context.Context) ([]Clone, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, target, COALESCE(backup_id, 0), COALESCE(server, ''), database, COALESCE(masking_profile, ''),
+		       status, COALESCE(error, ''), created_at, expires_at, dropped_at
+		FROM clones
+		ORDER BY created_at DESC
+		LIMIT 100
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clones []Clone
+	for rows.Next() {
+		var c Clone
+		var dropped sql.NullTime
+		if err := rows.Scan(&c.ID, &c.Target, &c.BackupID, &c.Server, &c.Database, &c.MaskingProfile,
+			&c.Status, &c.Error, &c.CreatedAt, &c.ExpiresAt, &dropped); err != nil {
+			return nil, err
+		}
+		if dropped.Valid {
+			c.DroppedAt = &dropped.Time
+		}
+		clones = append(clones, c)
+	}
+	return clones, rows.Err()
+}
+
+// DropClone 删除克隆的数据库
+func (s *Service) DropClone(ctx context.Context, id int64) error {
+	var server, database, status string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(server, ''), database, status FROM clones WHERE id = $1 AND dropped_at IS NULL
+	`, id).Scan(&server, &database, &status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("clone %d not found or already dropped", id)
+	}
+	if err != nil {
+		return err
+	}
+	if status == "creating" {
+		return fmt.Errorf("clone %d is still being created", id)
+	}
+
+	conn, err := s.serverConn(server, database)
+	if err != nil {
+		return err
+	}
+	if status == "ready" {
+		if err := s.dropDatabase(ctx, conn); err != nil {
+			return err
+		}
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE clones SET status = 'dropped', dropped_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+	return err
+}
+
+// RunCloneReaper 定期删除过期的克隆，直到 ctx 结束
+func (s *Service) RunCloneReaper(ctx context.Context) {
+	ticker := time.NewTicker(cloneReaperInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.expireClones(ctx)
+		}
+	}
+}
+
+func (s *Service) expireClones(ctx context.Context) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id FROM clones
+		WHERE dropped_at IS NULL AND status <> 'creating' AND expires_at < CURRENT_TIMESTAMP
+	`)
+	if err != nil {
+		log.Printf("Failed to query expired clones: %v", err)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := s.DropClone(ctx, id); err != nil {
+			log.Printf("Failed to drop expired clone %d: %v", id, err)
+		}
+	}
+}
+
+// createDatabase 在目标服务器上新建空数据库，已存在时报错以免覆盖
+func (s *Service) createDatabase(ctx context.Context, conn config.DatabaseConfig) error {
+	admin := conn
+	admin.Database = "postgres"
+	return s.runRestoreCommand(ctx, admin, "psql", append(connArgs(admin),
+		"-v", "ON_ERROR_STOP=1", "-c", "CREATE DATABASE "+quoteIdent(conn.Database)), nil)
+}
+
+// dropDatabase 删除数据库并断开现有连接（需要 PostgreSQL 13+）
+func (s *Service) dropDatabase(ctx context.Context, conn config.DatabaseConfig) error {
+	admin := conn
+	admin.Database = "postgres"
+	return s.runRestoreCommand(ctx, admin, "psql", append(connArgs(admin),
+		"-v", "ON_ERROR_STOP=1", "-c", "DROP DATABASE IF EXISTS "+quoteIdent(conn.Database)+" WITH (FORCE)"), nil)
+}
@@ -0,0 +1,234 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"pg-backup/internal/config"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// 支持的压缩算法
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionLZ4  = "lz4"
+)
+
+// 压缩执行方式
+const (
+	CompressByPgDump = "pg_dump" // 由 pg_dump 自身压缩（zstd/lz4 需要 PostgreSQL 16+ 的 pg_dump）
+	CompressByStream = "stream"  // pg_dump 输出到标准输出，由 pg-backup 流式压缩
+)
+
+// pgDumpMultiCompressVersion pg_dump 从该版本开始支持 --compress=method:detail
+const pgDumpMultiCompressVersion = 16
+
+// compressionConfig 解析本次备份的压缩设置，填充默认级别并确定压缩方式
+func (s *Service) compressionConfig(opts Options) (config.CompressionConfig, error) {
+	var c config.CompressionConfig
+	switch {
+	case opts.Compress != nil:
+		c = *opts.Compress
+	case !opts.Compression:
+		c.Algorithm = CompressionNone
+	default:
+		c = s.config.Compression
+	}
+
+	if c.Algorithm == "" {
+		c.Algorithm = CompressionGzip
+	}
+
+	switch c.Algorithm {
+	case CompressionNone:
+		c.Level, c.Threads, c.Method = 0, 0, ""
+		return c, nil
+	case CompressionGzip:
+		if c.Level == 0 {
+			c.Level = 6
+		}
+		if c.Level < 1 || c.Level > 9 {
+			return c, fmt.Errorf("gzip level must be between 1 and 9, got %d", c.Level)
+		}
+	case CompressionZstd:
+		if c.Level == 0 {
+			c.Level = 3
+		}
+		if c.Level < 1 || c.Level > 22 {
+			return c, fmt.Errorf("zstd level must be between 1 and 22, got %d", c.Level)
+		}
+	case CompressionLZ4:
+		if c.Level < 0 || c.Level > 9 {
+			return c, fmt.Errorf("lz4 level must be between 0 and 9, got %d", c.Level)
+		}
+	default:
+		return c, fmt.Errorf("unsupported compression algorithm: %s", c.Algorithm)
+	}
+
+	if c.Threads > 1 && c.Algorithm != CompressionZstd {
+		return c, fmt.Errorf("multithreaded compression is only supported for zstd")
+	}
+
+	return c, s.resolveCompressionMethod(&c, opts.pgDumpName())
+}
+
+// resolveCompressionMethod 未指定方式时优先交给 pg_dump，pg_dump 不支持时改为流式压缩
+func (s *Service) resolveCompressionMethod(c *config.CompressionConfig, pgDump string) error {
+	pgDumpCapable := c.Algorithm == CompressionGzip
+	if !pgDumpCapable {
+		if major, err := clientMajorVersion(pgDump); err == nil && major >= pgDumpMultiCompressVersion {
+			// pg_dump 的 zstd 不支持多线程
+			pgDumpCapable = c.Threads <= 1
+		}
+	}
+
+	switch c.Method {
+	case "":
+		if pgDumpCapable {
+			c.Method = CompressByPgDump
+		} else {
+			c.Method = CompressByStream
+		}
+	case CompressByPgDump:
+		if !pgDumpCapable {
+			return fmt.Errorf("pg_dump on this host cannot compress with %s (requires PostgreSQL %d+ and single thread)", c.Algorithm, pgDumpMultiCompressVersion)
+		}
+	case CompressByStream:
+	default:
+		return fmt.Errorf("unsupported compression method: %s", c.Method)
+	}
+	return nil
+}
+
+// compressionExt 返回压缩算法对应的文件扩展名
+func compressionExt(algorithm string) string {
+	switch algorithm {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	case CompressionLZ4:
+		return ".lz4"
+	default:
+		return ""
+	}
+}
+
+// pgDumpCompressArg 返回交给 pg_dump 压缩时的参数
+func pgDumpCompressArg(c config.CompressionConfig) string {
+	switch c.Algorithm {
+	case CompressionGzip:
+		return fmt.Sprintf("--compress=%d", c.Level)
+	case CompressionZstd:
+		return fmt.Sprintf("--compress=zstd:level=%d", c.Level)
+	case CompressionLZ4:
+		if c.Level > 0 {
+			return fmt.Sprintf("--compress=lz4:%d", c.Level)
+		}
+		return "--compress=lz4"
+	default:
+		return ""
+	}
+}
+
+// newCompressor 创建流式压缩器
+func newCompressor(w io.Writer, c config.CompressionConfig) (io.WriteCloser, error) {
+	switch c.Algorithm {
+	case CompressionGzip:
+		return gzip.NewWriterLevel(w, c.Level)
+	case CompressionZstd:
+		threads := c.Threads
+		if threads < 1 {
+			threads = 1
+		}
+		return zstd.NewWriter(w,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.Level)),
+			zstd.WithEncoderConcurrency(threads),
+		)
+	case CompressionLZ4:
+		zw := lz4.NewWriter(w)
+		if c.Level > 0 {
+			if err := zw.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(1 << (8 + c.Level - 1)))); err != nil {
+				return nil, err
+			}
+		}
+		return zw, nil
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// newDecompressor 按备份清单记录的算法创建解压器
+func newDecompressor(r io.Reader, algorithm string) (io.ReadCloser, error) {
+	switch algorithm {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case CompressionLZ4:
+		return io.NopCloser(lz4.NewReader(r)), nil
+	case CompressionNone, "":
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm: %s", algorithm)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// verifyDumpContent 解压并流式检查 dump 中是否包含 CREATE 或 INSERT 语句
+func verifyDumpContent(path, algorithm string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	rc, err := newDecompressor(bufio.NewReader(file), algorithm)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	patterns := [][]byte{[]byte("CREATE"), []byte("INSERT")}
+	const overlap = 6
+	buf := make([]byte, 64*1024)
+	carry := 0
+	for {
+		n, err := rc.Read(buf[carry:])
+		window := buf[:carry+n]
+		for _, p := range patterns {
+			if bytes.Contains(window, p) {
+				return true, nil
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// 保留末尾几个字节，避免关键字跨越两次读取
+		if len(window) > overlap {
+			carry = copy(buf, window[len(window)-overlap:])
+		} else {
+			carry = len(window)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"testing"
+
+	"pg-backup/internal/config"
+)
+
+func TestCompressionConfig(t *testing.T) {
+	s := &Service{config: &config.Config{
+		Compression: config.CompressionConfig{Algorithm: CompressionGzip, Level: 9},
+	}}
+	compress := func(c config.CompressionConfig) *config.CompressionConfig { return &c }
+
+	tests := []struct {
+		name    string
+		opts    Options
+		want    config.CompressionConfig
+		wantErr bool
+	}{
+		{
+			name: "disabled",
+			opts: Options{},
+			want: config.CompressionConfig{Algorithm: CompressionNone},
+		},
+		{
+			name: "configured default",
+			opts: Options{Compression: true},
+			want: config.CompressionConfig{Algorithm: CompressionGzip, Level: 9, Method: CompressByPgDump},
+		},
+		{
+			name: "gzip default level",
+			opts: Options{Compress: compress(config.CompressionConfig{})},
+			want: config.CompressionConfig{Algorithm: CompressionGzip, Level: 6, Method: CompressByPgDump},
+		},
+		{
+			name: "none clears level",
+			opts: Options{Compress: compress(config.CompressionConfig{Algorithm: CompressionNone, Level: 5, Threads: 4})},
+			want: config.CompressionConfig{Algorithm: CompressionNone},
+		},
+		{
+			name: "zstd default level",
+			opts: Options{Compress: compress(config.CompressionConfig{Algorithm: CompressionZstd, Method: CompressByStream})},
+			want: config.CompressionConfig{Algorithm: CompressionZstd, Level: 3, Method: CompressByStream},
+		},
+		{
+			name: "zstd threads",
+			opts: Options{Compress: compress(config.CompressionConfig{Algorithm: CompressionZstd, Level: 19, Threads: 4, Method: CompressByStream})},
+			want: config.CompressionConfig{Algorithm: CompressionZstd, Level: 19, Threads: 4, Method: CompressByStream},
+		},
+		{
+			name: "lz4 level 0",
+			opts: Options{Compress: compress(config.CompressionConfig{Algorithm: CompressionLZ4, Method: CompressByStream})},
+			want: config.CompressionConfig{Algorithm: CompressionLZ4, Method: CompressByStream},
+		},
+		{
+			name:    "gzip level too high",
+			opts:    Options{Compress: compress(config.CompressionConfig{Algorithm: CompressionGzip, Level: 10})},
+			wantErr: true,
+		},
+		{
+			name:    "zstd level too high",
+			opts:    Options{Compress: compress(config.CompressionConfig{Algorithm: CompressionZstd, Level: 23})},
+			wantErr: true,
+		},
+		{
+			name:    "lz4 negative level",
+			opts:    Options{Compress: compress(config.CompressionConfig{Algorithm: CompressionLZ4, Level: -1})},
+			wantErr: true,
+		},
+		{
+			name:    "threads require zstd",
+			opts:    Options{Compress: compress(config.CompressionConfig{Algorithm: CompressionGzip, Threads: 2})},
+			wantErr: true,
+		},
+		{
+			name:    "unknown algorithm",
+			opts:    Options{Compress: compress(config.CompressionConfig{Algorithm: "bzip2"})},
+			wantErr: true,
+		},
+		{
+			name:    "unknown method",
+			opts:    Options{Compress: compress(config.CompressionConfig{Algorithm: CompressionGzip, Method: "external"})},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.compressionConfig(tt.opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
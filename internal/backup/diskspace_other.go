@@ -0,0 +1,10 @@
+//go:build !unix
+
+package backup
+
+import "fmt"
+
+// freeSpace 非 Unix 平台不支持检查剩余空间
+func freeSpace(path string) (int64, error) {
+	return 0, fmt.Errorf("free space check is not supported on this platform")
+}
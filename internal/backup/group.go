@@ -0,0 +1,275 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultSnapshotAttempts = 5
+	snapshotRetryDelay      = 200 * time.Millisecond
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// GroupRequest 一致性组备份的参数
+type GroupRequest struct {
+	// Databases 同一集群上需要相互一致的数据库
+	Databases []string `json:"databases" binding:"required"`
+	// Options 每个数据库的逻辑备份参数
+	Options Options `json:"options"`
+	// Attempts 各数据库快照不一致时重试的次数，0 表示 5 次
+	Attempts int `json:"attempts"`
+}
+
+// ConsistencyGroup 基于同一事务快照的一组逻辑备份
+type ConsistencyGroup struct {
+	ID        int64          `json:"id"`
+	Databases []string       `json:"databases"`
+	Snapshot  string         `json:"snapshot,omitempty"` // txid_current_snapshot()，各数据库相同
+	Status    string         `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Backups   []BackupRecord `json:"backups,omitempty"`
+}
+
+// exportedSnapshot 在一个数据库中持有的 REPEATABLE READ 事务及其导出的快照
+type exportedSnapshot struct {
+	db       *sql.DB
+	tx       *sql.Tx
+	id       string // pg_export_snapshot() 的返回值，供 pg_dump --snapshot 使用
+	snapshot string // txid_current_snapshot()，用于判断各数据库的快照是否一致
+}
+
+func (e *exportedSnapshot) close() {
+	if e.tx != nil {
+		e.tx.Rollback()
+	}
+	e.db.Close()
+}
+
+// CreateConsistentBackup 校验参数并创建一致性组，在后台为每个数据库导出快照并并行执行 pg_dump
+func (s *Service) CreateConsistentBackup(req GroupRequest) (*ConsistencyGroup, error) {
+	if len(req.Databases) < 2 {
+		return nil, fmt.Errorf("a consistency group needs at least two databases")
+	}
+	for i, db := range req.Databases {
+		if strings.TrimSpace(db) == "" {
+			return nil, fmt.Errorf("database name is required")
+		}
+		if containsString(req.Databases[:i], db) {
+			return nil, fmt.Errorf("database %s is listed more than once", db)
+		}
+	}
+	opts := req.Options
+	if opts.Kind != "" && opts.Kind != KindLogical {
+		return nil, fmt.Errorf("consistency groups only support logical backups")
+	}
+	if err := s.ValidateOptions(opts); err != nil {
+		return nil, err
+	}
+
+	group := &ConsistencyGroup{Databases: req.Databases, Status: "running"}
+	err := s.db.QueryRow(`
+		INSERT INTO consistency_groups (databases, status) VALUES ($1, $2)
+		RETURNING id, created_at
+	`, pq.Array(req.Databases), group.Status).Scan(&group.ID, &group.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := req.Attempts
+	if attempts <= 0 {
+		attempts = defaultSnapshotAttempts
+	}
+	go s.runConsistentBackup(group, opts, attempts)
+	return group, nil
+}
+
+// runConsistentBackup 导出一致的快照后并行备份，所有 pg_dump 结束后才释放快照
+func (s *Service) runConsistentBackup(group *ConsistencyGroup, opts Options, attempts int) {
+	finish := func(status string, snapshot string, err error) {
+		var errMsg string
+		if err != nil {
+			errMsg = err.Error()
+			log.Printf("Consistency group %d failed: %v", group.ID, err)
+		}
+		s.db.Exec(`
+			UPDATE consistency_groups SET status = $1, snapshot = $2, error = $3, completed_at = CURRENT_TIMESTAMP
+			WHERE id = $4
+		`, status, snapshot, errMsg, group.ID)
+	}
+
+	snapshots, err := s.exportConsistentSnapshots(group.Databases, attempts)
+	if err != nil {
+		finish("failed", "", err)
+		return
+	}
+	defer func() {
+		for _, e := range snapshots {
+			e.close()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(group.Databases))
+	for i, db := range group.Databases {
+		dbOpts := opts
+		dbOpts.Kind = KindLogical
+		dbOpts.Database, dbOpts.snapshot, dbOpts.group = db, snapshots[i].id, group.ID
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.CreateBackup(dbOpts)
+		}(i)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", group.Databases[i], err))
+		}
+	}
+	if len(failed) > 0 {
+		finish("failed", snapshots[0].snapshot, fmt.Errorf("%s", strings.Join(failed, "; ")))
+		return
+	}
+	finish("completed", snapshots[0].snapshot, nil)
+}
+
+// exportConsistentSnapshots 在每个数据库中开启 REPEATABLE READ 事务并导出快照。
+// 导出的快照只能在同一数据库中导入，因此各数据库分别导出；事务 ID 在集群内共享，
+// 当所有事务的 txid_current_snapshot() 相同时，各快照看到的是同一组已提交事务，
+// 不同时释放并重试，直到一致或用完重试次数。
+func (s *Service) exportConsistentSnapshots(databases []string, attempts int) ([]*exportedSnapshot, error) {
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		snapshots, err := s.exportSnapshots(databases)
+		if err != nil {
+			return nil, err
+		}
+
+		consistent := true
+		for i, e := range snapshots {
+			if e.snapshot != snapshots[0].snapshot {
+				consistent = false
+				lastErr = fmt.Errorf("snapshot of %s (%s) differs from %s (%s)",
+					databases[i], e.snapshot, databases[0], snapshots[0].snapshot)
+				break
+			}
+		}
+		if consistent {
+			return snapshots, nil
+		}
+
+		for _, e := range snapshots {
+			e.close()
+		}
+		time.Sleep(snapshotRetryDelay)
+	}
+	return nil, fmt.Errorf("could not obtain consistent snapshots after %d attempts: %w", attempts, lastErr)
+}
+
+// exportSnapshots 先在所有数据库中开启事务，再依次取快照，尽量缩短各快照之间的间隔
+func (s *Service) exportSnapshots(databases []string) ([]*exportedSnapshot, error) {
+	ctx := context.Background()
+	snapshots := make([]*exportedSnapshot, 0, len(databases))
+	fail := func(err error) ([]*exportedSnapshot, error) {
+		for _, e := range snapshots {
+			e.close()
+		}
+		return nil, err
+	}
+
+	for _, name := range databases {
+		conn := s.primaryConn(Options{Database: name})
+		db, err := openDatabase(conn)
+		if err != nil {
+			return fail(err)
+		}
+		e := &exportedSnapshot{db: db}
+		snapshots = append(snapshots, e)
+		if e.tx, err = db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}); err != nil {
+			return fail(fmt.Errorf("failed to begin transaction on %s: %w", name, err))
+		}
+	}
+
+	for i, e := range snapshots {
+		if err := e.tx.QueryRowContext(ctx, "SELECT pg_export_snapshot(), txid_current_snapshot()::text").
+			Scan(&e.id, &e.snapshot); err != nil {
+			return fail(fmt.Errorf("failed to export snapshot on %s: %w", databases[i], err))
+		}
+	}
+	return snapshots, nil
+}
+
+// GetConsistencyGroups 列出最近的一致性组及其备份
+func (s *Service) GetConsistencyGroups(ctx context.Context) ([]ConsistencyGroup, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, databases, COALESCE(snapshot, ''), status, COALESCE(error, ''), created_at
+		FROM consistency_groups
+		ORDER BY created_at DESC
+		LIMIT 100
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []ConsistencyGroup
+	index := make(map[int64]int)
+	for rows.Next() {
+		var g ConsistencyGroup
+		if err := rows.Scan(&g.ID, pq.Array(&g.Databases), &g.Snapshot, &g.Status, &g.Error, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		index[g.ID] = len(groups)
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return groups, nil
+	}
+
+	ids := make([]int64, 0, len(groups))
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+	backups, err := s.db.QueryContext(ctx, `
+		SELECT id, name, type, kind, COALESCE(size, ''), status, timestamp, COALESCE(path, ''), COALESCE(error, ''),
+		       COALESCE(target, ''), group_id
+		FROM backup_records
+		WHERE group_id = ANY($1)
+		ORDER BY id
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer backups.Close()
+	for backups.Next() {
+		var r BackupRecord
+		if err := backups.Scan(&r.ID, &r.Name, &r.Type, &r.Kind, &r.Size, &r.Status, &r.Timestamp, &r.Path, &r.Error,
+			&r.Target, &r.GroupID); err != nil {
+			return nil, err
+		}
+		g := &groups[index[r.GroupID]]
+		g.Backups = append(g.Backups, r)
+	}
+	return groups, backups.Err()
+}
+
+// fileNameSafe 将名称中不适合做文件名的字符替换为下划线
+func fileNameSafe(name string) string {
+	return unsafeFileChars.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,259 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultHistoryPageSize = 50
+	maxHistoryPageSize     = 500
+	maxLabelLength         = 64
+	maxLabels              = 20
+)
+
+// historySortColumns 备份历史允许的排序字段
+var historySortColumns = map[string]string{
+	"timestamp": "timestamp",
+	"size":      "size_bytes",
+	"name":      "name",
+	"status":    "status",
+}
+
+// BackupFilter 备份历史的筛选、排序和分页条件，零值表示不限制
+type BackupFilter struct {
+	Status string `form:"status"`
+	Target string `form:"target"`
+	JobID  int64  `form:"job"`
+	// Labels 需要同时带有的标签
+	Labels []string `form:"label"`
+	// Query 按名称或备注模糊匹配
+	Query string `form:"q"`
+	// From、To 备份时间范围 [From, To)，RFC 3339 格式
+	From time.Time `form:"from"`
+	To   time.Time `form:"to"`
+	// MinSize、MaxSize 备份大小范围（字节）
+	MinSize int64 `form:"minSize"`
+	MaxSize int64 `form:"maxSize"`
+	// Pinned 为 true 只返回固定的备份，为 false 只返回未固定的
+	Pinned *bool `form:"pinned"`
+
+	// Sort 排序字段：timestamp（默认）、size、name、status；Order 为 asc 或 desc（默认）
+	Sort  string `form:"sort"`
+	Order string `form:"order"`
+	// Page 从 1 开始，PageSize 默认 50，最大 500
+	Page     int `form:"page"`
+	PageSize int `form:"pageSize"`
+}
+
+// BackupPage 一页备份历史，Total 为符合条件的记录总数
+type BackupPage struct {
+	Items    []BackupRecord `json:"items"`
+	Total    int64          `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"pageSize"`
+}
+
+// BackupAnnotation 修改备份的标签和备注，为空的字段保持不变
+type BackupAnnotation struct {
+	Labels *[]string `json:"labels"`
+	Notes  *string   `json:"notes"`
+}
+
+// historyQuery 由筛选条件生成的查询片段，$1 为未记录目标的旧备份默认的数据库
+type historyQuery struct {
+	where    string
+	args     []interface{}
+	orderBy  string
+	page     int
+	pageSize int
+}
+
+// buildHistoryQuery 校验排序和分页参数，按筛选条件生成 WHERE 子句和参数
+func buildHistoryQuery(filter BackupFilter, defaultTarget string) (*historyQuery, error) {
+	sortColumn, ok := historySortColumns[filter.Sort]
+	if filter.Sort == "" {
+		sortColumn, ok = "timestamp", true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort field: %s (supported: timestamp, size, name, status)", filter.Sort)
+	}
+	order := "DESC"
+	switch strings.ToLower(filter.Order) {
+	case "", "desc":
+	case "asc":
+		order = "ASC"
+	default:
+		return nil, fmt.Errorf("unsupported order: %s (supported: asc, desc)", filter.Order)
+	}
+	q := &historyQuery{
+		orderBy:  fmt.Sprintf("%s %s NULLS LAST, id %s", sortColumn, order, order),
+		page:     filter.Page,
+		pageSize: filter.PageSize,
+	}
+	if q.page <= 0 {
+		q.page = 1
+	}
+	if q.pageSize <= 0 {
+		q.pageSize = defaultHistoryPageSize
+	}
+	if q.pageSize > maxHistoryPageSize {
+		q.pageSize = maxHistoryPageSize
+	}
+
+	q.args = []interface{}{defaultTarget}
+	var conds []string
+	where := func(cond string, arg interface{}) {
+		q.args = append(q.args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(q.args)))
+	}
+	if filter.Status != "" {
+		where("status = $%d", filter.Status)
+	}
+	if filter.Target != "" {
+		where("COALESCE(target, $1) = $%d", filter.Target)
+	}
+	if filter.JobID != 0 {
+		where("job_id = $%d", filter.JobID)
+	}
+	if len(filter.Labels) > 0 {
+		where("labels @> $%d::text[]", pq.Array(filter.Labels))
+	}
+	if filter.Query != "" {
+		where("(name ILIKE $%[1]d OR notes ILIKE $%[1]d)", "%"+filter.Query+"%")
+	}
+	if !filter.From.IsZero() {
+		where("timestamp >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("timestamp < $%d", filter.To)
+	}
+	if filter.MinSize > 0 {
+		where("size_bytes >= $%d", filter.MinSize)
+	}
+	if filter.MaxSize > 0 {
+		where("size_bytes <= $%d", filter.MaxSize)
+	}
+	if filter.Pinned != nil {
+		where(pinActive+" = $%d", *filter.Pinned)
+	}
+	if len(conds) > 0 {
+		q.where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	return q, nil
+}
+
+// GetBackupHistory 按条件分页查询备份历史记录
+func (s *Service) GetBackupHistory(ctx context.Context, filter BackupFilter) (*BackupPage, error) {
+	q, err := buildHistoryQuery(filter, s.config.Database.Database)
+	if err != nil {
+		return nil, err
+	}
+	args := q.args
+
+	result := &BackupPage{Items: []BackupRecord{}, Page: q.page, PageSize: q.pageSize}
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM backup_records "+q.where, args...).
+		Scan(&result.Total); err != nil {
+		return nil, err
+	}
+
+	args = append(args, q.pageSize, (q.page-1)*q.pageSize)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, name, type, kind, COALESCE(size, ''), COALESCE(size_bytes, 0), status, timestamp, COALESCE(path, ''),
+		       COALESCE(error, ''), COALESCE(start_lsn, ''), COALESCE(stop_lsn, ''), COALESCE(timeline, 0),
+		       COALESCE(parent_id, 0), selection, COALESCE(source_host, ''), COALESCE(target, $1), COALESCE(group_id, 0),
+		       COALESCE(job_id, 0), labels, COALESCE(notes, ''),
+		       pinned_at, COALESCE(pinned_by, ''), COALESCE(pin_reason, ''), pin_expires_at
+		FROM backup_records
+		%s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d
+	`, q.where, q.orderBy, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record BackupRecord
+		var selection []byte
+		var pinnedAt, pinExpiresAt sql.NullTime
+		var pinnedBy, pinReason string
+		err := rows.Scan(&record.ID, &record.Name, &record.Type, &record.Kind, &record.Size, &record.SizeBytes,
+			&record.Status, &record.Timestamp, &record.Path, &record.Error,
+			&record.StartLSN, &record.StopLSN, &record.Timeline, &record.ParentID, &selection, &record.SourceHost,
+			&record.Target, &record.GroupID, &record.JobID, pq.Array(&record.Labels), &record.Notes,
+			&pinnedAt, &pinnedBy, &pinReason, &pinExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		record.Pin = scanPin(pinnedAt, pinnedBy, pinReason, pinExpiresAt)
+		if len(selection) > 0 {
+			json.Unmarshal(selection, &record.Selection)
+		}
+		result.Items = append(result.Items, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.fillBackupChains(result.Items); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AnnotateBackup 修改备份的标签和备注
+func (s *Service) AnnotateBackup(ctx context.Context, id int64, a BackupAnnotation) error {
+	if a.Labels == nil && a.Notes == nil {
+		return fmt.Errorf("labels or notes is required")
+	}
+	var labels interface{}
+	if a.Labels != nil {
+		normalized, err := normalizeLabels(*a.Labels)
+		if err != nil {
+			return err
+		}
+		labels = pq.Array(normalized)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE backup_records
+		SET labels = COALESCE($1::text[], labels), notes = COALESCE($2, notes)
+		WHERE id = $3
+	`, labels, a.Notes, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("backup %d not found", id)
+	}
+	return nil
+}
+
+// normalizeLabels 去掉标签两端的空白和重复项，并检查数量和长度
+func normalizeLabels(labels []string) ([]string, error) {
+	if len(labels) > maxLabels {
+		return nil, fmt.Errorf("at most %d labels are allowed", maxLabels)
+	}
+	normalized := make([]string, 0, len(labels))
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			return nil, fmt.Errorf("label must not be empty")
+		}
+		if len(label) > maxLabelLength {
+			return nil, fmt.Errorf("label %q is longer than %d characters", label, maxLabelLength)
+		}
+		if !containsString(normalized, label) {
+			normalized = append(normalized, label)
+		}
+	}
+	return normalized, nil
+}
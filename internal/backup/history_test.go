@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestNormalizeLabels(t *testing.T) {
+	tooMany := make([]string, maxLabels+1)
+	for i := range tooMany {
+		tooMany[i] = string(rune('a' + i))
+	}
+
+	tests := []struct {
+		name    string
+		labels  []string
+		want    []string
+		wantErr bool
+	}{
+		{"nil", nil, []string{}, false},
+		{"trims and dedups", []string{" prod ", "prod", "release-1.2", "nightly"}, []string{"prod", "release-1.2", "nightly"}, false},
+		{"max length", []string{strings.Repeat("x", maxLabelLength)}, []string{strings.Repeat("x", maxLabelLength)}, false},
+		{"empty label", []string{"prod", "  "}, nil, true},
+		{"too long", []string{strings.Repeat("x", maxLabelLength+1)}, nil, true},
+		{"too many", tooMany, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeLabels(tt.labels)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("normalizeLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildHistoryQuery(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	pinned := true
+
+	tests := []struct {
+		name       string
+		filter     BackupFilter
+		where      string
+		args       []interface{}
+		orderBy    string
+		page, size int
+		wantErr    bool
+	}{
+		{
+			name:    "defaults",
+			filter:  BackupFilter{},
+			args:    []interface{}{"app"},
+			orderBy: "timestamp DESC NULLS LAST, id DESC",
+			page:    1,
+			size:    defaultHistoryPageSize,
+		},
+		{
+			name: "all filters",
+			filter: BackupFilter{
+				Status: "completed", Target: "orders", JobID: 7, Labels: []string{"prod"}, Query: "nightly",
+				From: from, MinSize: 1024, Pinned: &pinned,
+				Sort: "size", Order: "ASC", Page: 3, PageSize: 20,
+			},
+			where: "WHERE status = $2 AND COALESCE(target, $1) = $3 AND job_id = $4 AND labels @> $5::text[]" +
+				" AND (name ILIKE $6 OR notes ILIKE $6) AND timestamp >= $7 AND size_bytes >= $8 AND " + pinActive + " = $9",
+			args:    []interface{}{"app", "completed", "orders", int64(7), pq.Array([]string{"prod"}), "%nightly%", from, int64(1024), true},
+			orderBy: "size_bytes ASC NULLS LAST, id ASC",
+			page:    3,
+			size:    20,
+		},
+		{
+			name:    "page size is capped",
+			filter:  BackupFilter{Page: -1, PageSize: maxHistoryPageSize + 1, MaxSize: 10},
+			where:   "WHERE size_bytes <= $2",
+			args:    []interface{}{"app", int64(10)},
+			orderBy: "timestamp DESC NULLS LAST, id DESC",
+			page:    1,
+			size:    maxHistoryPageSize,
+		},
+		{
+			name:    "unknown sort",
+			filter:  BackupFilter{Sort: "path"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown order",
+			filter:  BackupFilter{Order: "up"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := buildHistoryQuery(tt.filter, "app")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", q)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if q.where != tt.where {
+				t.Errorf("where = %q, want %q", q.where, tt.where)
+			}
+			if !reflect.DeepEqual(q.args, tt.args) {
+				t.Errorf("args = %#v, want %#v", q.args, tt.args)
+			}
+			if q.orderBy != tt.orderBy || q.page != tt.page || q.pageSize != tt.size {
+				t.Errorf("order/page = %q %d %d, want %q %d %d", q.orderBy, q.page, q.pageSize, tt.orderBy, tt.page, tt.size)
+			}
+		})
+	}
+}
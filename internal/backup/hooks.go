@@ -0,0 +1,262 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"pg-backup/internal/config"
+)
+
+// 钩子执行阶段
+const (
+	HookPre     = "pre"     // 备份开始前，失败时中止备份
+	HookPost    = "post"    // 备份命令结束后，无论成败
+	HookSuccess = "success" // 备份成功完成后
+	HookFailure = "failure" // 备份失败后
+)
+
+// 钩子类型
+const (
+	HookSQL   = "sql"   // 在备份的数据库上用 psql 执行 SQL
+	HookShell = "shell" // 用 sh -c 执行命令
+	HookHTTP  = "http"  // 发送 HTTP 请求
+)
+
+const (
+	defaultHookTimeout = time.Minute
+	maxHookOutput      = 64 * 1024
+	// hookWaitDelay 超时后等待子进程关闭输出的时间，避免后台子进程占住管道
+	hookWaitDelay = 5 * time.Second
+)
+
+// Hook 备份前后执行的动作，输出写入备份的运行日志
+type Hook struct {
+	Name  string `json:"name,omitempty"`
+	Stage string `json:"stage"`
+	Type  string `json:"type"`
+	// SQL 为 sql 类型的语句，Command 为 shell 类型的命令
+	SQL     string `json:"sql,omitempty"`
+	Command string `json:"command,omitempty"`
+	// URL、Method（默认 POST）、Headers、Body 为 http 类型的请求，
+	// Body 为空时发送包含备份 ID、阶段和错误的 JSON
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	// Timeout 超时时间（如 "30s"），为空时为 1 分钟
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func validateHooks(hooks []Hook) error {
+	for i, h := range hooks {
+		label := h.label(i)
+		switch h.Stage {
+		case HookPre, HookPost, HookSuccess, HookFailure:
+		default:
+			return fmt.Errorf("hook %s: unsupported stage %q (supported: pre, post, success, failure)", label, h.Stage)
+		}
+		switch h.Type {
+		case HookSQL:
+			if strings.TrimSpace(h.SQL) == "" {
+				return fmt.Errorf("hook %s: sql is required", label)
+			}
+		case HookShell:
+			if strings.TrimSpace(h.Command) == "" {
+				return fmt.Errorf("hook %s: command is required", label)
+			}
+		case HookHTTP:
+			if !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+				return fmt.Errorf("hook %s: url must be http or https", label)
+			}
+		default:
+			return fmt.Errorf("hook %s: unsupported type %q (supported: sql, shell, http)", label, h.Type)
+		}
+		if h.Timeout != "" {
+			if d, err := time.ParseDuration(h.Timeout); err != nil || d <= 0 {
+				return fmt.Errorf("hook %s: invalid timeout %s", label, h.Timeout)
+			}
+		}
+	}
+	return nil
+}
+
+func (h Hook) label(i int) string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+func (h Hook) timeout() time.Duration {
+	if d, err := time.ParseDuration(h.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultHookTimeout
+}
+
+// runHooks 依次执行某一阶段的钩子并写入运行日志，返回第一个失败的错误。SQL 钩子在 conn（被备份的数据库）上执行。
+// 前置钩子失败时立即返回，其余阶段继续执行后面的钩子。
+func (s *Service) runHooks(id int64, conn config.DatabaseConfig, hooks []Hook, stage string, backupErr error) error {
+	var firstErr error
+	for i, h := range hooks {
+		if h.Stage != stage {
+			continue
+		}
+		label := h.label(i)
+		start := time.Now()
+		output, err := s.runHook(id, conn, h, backupErr)
+
+		status := "ok"
+		if err != nil {
+			status = "failed: " + err.Error()
+		}
+		s.appendRunLog(id, fmt.Sprintf("[%s] %s hook %s (%s) %s in %s\n%s",
+			start.Format(time.RFC3339), stage, label, h.Type, status, time.Since(start).Round(time.Millisecond), output))
+
+		if err != nil {
+			err = fmt.Errorf("%s hook %s failed: %w", stage, label, err)
+			if stage == HookPre {
+				return err
+			}
+			log.Printf("Backup %d: %v", id, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// runFinishHooks 按备份结果执行 success 或 failure 阶段的钩子
+func (s *Service) runFinishHooks(id int64, conn config.DatabaseConfig, hooks []Hook, backupErr error) {
+	if backupErr != nil {
+		s.runHooks(id, conn, hooks, HookFailure, backupErr)
+		return
+	}
+	s.runHooks(id, conn, hooks, HookSuccess, nil)
+}
+
+// runHook 执行单个钩子，返回截断后的输出
+func (s *Service) runHook(id int64, conn config.DatabaseConfig, h Hook, backupErr error) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout())
+	defer cancel()
+
+	var errMsg string
+	if backupErr != nil {
+		errMsg = backupErr.Error()
+	}
+	var out limitedBuffer
+
+	var err error
+	switch h.Type {
+	case HookSQL:
+		cmd := exec.CommandContext(ctx, "psql", append(connArgs(conn), "-v", "ON_ERROR_STOP=1", "-c", h.SQL)...)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
+		cmd.Stdout, cmd.Stderr = &out, &out
+		cmd.WaitDelay = hookWaitDelay
+		err = cmd.Run()
+	case HookShell:
+		cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+		cmd.Env = append(os.Environ(),
+			fmt.Sprintf("PGBACKUP_BACKUP_ID=%d", id),
+			fmt.Sprintf("PGBACKUP_STAGE=%s", h.Stage),
+			fmt.Sprintf("PGBACKUP_ERROR=%s", errMsg),
+		)
+		cmd.Stdout, cmd.Stderr = &out, &out
+		cmd.WaitDelay = hookWaitDelay
+		err = cmd.Run()
+	case HookHTTP:
+		err = s.runHTTPHook(ctx, id, h, errMsg, &out)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", h.timeout())
+	}
+	return out.String(), err
+}
+
+func (s *Service) runHTTPHook(ctx context.Context, id int64, h Hook, errMsg string, out io.Writer) error {
+	method := h.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	body := h.Body
+	if body == "" {
+		data, _ := json.Marshal(map[string]interface{}{
+			"backupId": id,
+			"stage":    h.Stage,
+			"error":    errMsg,
+		})
+		body = string(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, h.URL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	fmt.Fprintf(out, "HTTP %s\n", resp.Status)
+	io.Copy(out, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// appendRunLog 追加备份的运行日志
+func (s *Service) appendRunLog(id int64, text string) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	if _, err := s.db.Exec("UPDATE backup_records SET run_log = COALESCE(run_log, '') || $1 WHERE id = $2", text, id); err != nil {
+		log.Printf("Failed to append run log of backup %d: %v", id, err)
+	}
+}
+
+// GetRunLog 返回备份的运行日志
+func (s *Service) GetRunLog(ctx context.Context, id int64) (string, error) {
+	var runLog string
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(run_log, '') FROM backup_records WHERE id = $1", id).Scan(&runLog)
+	return runLog, err
+}
+
+// limitedBuffer 只保留前 maxHookOutput 字节的输出，超出部分丢弃但不报错，避免阻塞子进程
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxHookOutput - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n... output truncated\n"
+	}
+	return b.buf.String()
+}
@@ -0,0 +1,274 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pgIncrementalVersion 支持 pg_basebackup --incremental 和 pg_combinebackup 的最低版本
+const pgIncrementalVersion = 17
+
+// parentBackup 增量备份所基于的父备份
+type parentBackup struct {
+	id        int64
+	manifest  BackupFile // 父备份的 backup_manifest
+	localPath string     // 下载到本地的 backup_manifest，作为 --incremental 的参数
+}
+
+// prepareIncremental 确定父备份并下载其 backup_manifest。服务端需开启 summarize_wal。
+func (s *Service) prepareIncremental(ctx context.Context, parentID int64, localPath string) (*parentBackup, error) {
+	if version, err := clientMajorVersion("pg_basebackup"); err != nil {
+		return nil, err
+	} else if version < pgIncrementalVersion {
+		return nil, fmt.Errorf("incremental backups require pg_basebackup %d or later, found %d", pgIncrementalVersion, version)
+	}
+
+	if parentID == 0 {
+		err := s.db.QueryRowContext(ctx, `
+			SELECT id FROM backup_records
+			WHERE kind IN ($1, $2) AND status = 'completed' AND type = $3
+			ORDER BY completed_at DESC NULLS LAST
+			LIMIT 1
+		`, KindPhysical, KindIncremental, s.config.Storage.Type).Scan(&parentID)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no completed physical backup to base the incremental backup on")
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var kind, status string
+		err := s.db.QueryRowContext(ctx, "SELECT kind, status FROM backup_records WHERE id = $1", parentID).Scan(&kind, &status)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("parent backup %d not found", parentID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if (kind != KindPhysical && kind != KindIncremental) || status != "completed" {
+			return nil, fmt.Errorf("backup %d cannot be used as parent (kind: %s, status: %s)", parentID, kind, status)
+		}
+	}
+
+	manifest, err := s.loadManifest(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil || manifest.Physical == nil {
+		return nil, fmt.Errorf("parent backup %d has no physical manifest", parentID)
+	}
+
+	algorithm := CompressionNone
+	if manifest.Compression != nil {
+		algorithm = manifest.Compression.Algorithm
+	}
+	for _, file := range manifest.Files {
+		if strings.TrimSuffix(file.Name, compressionExt(algorithm)) != "backup_manifest" {
+			continue
+		}
+		if err := s.copyBackupFile(ctx, file, algorithm, localPath); err != nil {
+			return nil, fmt.Errorf("failed to fetch backup_manifest of backup %d: %w", parentID, err)
+		}
+		return &parentBackup{id: parentID, manifest: file, localPath: localPath}, nil
+	}
+	return nil, fmt.Errorf("parent backup %d has no backup_manifest", parentID)
+}
+
+// backupChain 返回从完整备份到 manifest 的整条备份链
+func (s *Service) backupChain(ctx context.Context, manifest *Manifest) ([]*Manifest, error) {
+	chain := []*Manifest{manifest}
+	for m := manifest; m.Physical != nil && m.Physical.ParentID != 0; {
+		parent, err := s.loadManifest(ctx, m.Physical.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil || parent.Physical == nil {
+			return nil, fmt.Errorf("parent backup %d has no physical manifest", m.Physical.ParentID)
+		}
+		chain = append([]*Manifest{parent}, chain...)
+		m = parent
+	}
+	return chain, nil
+}
+
+// restoreIncremental 依次解压备份链中的每个备份，再用 pg_combinebackup 合并为完整的数据目录
+func (s *Service) restoreIncremental(ctx context.Context, manifest *Manifest, opts RestoreOptions) error {
+	dataDir, err := filepath.Abs(opts.DataDirectory)
+	if err != nil {
+		return err
+	}
+	if err := ensureEmptyDir(dataDir); err != nil {
+		return err
+	}
+
+	chain, err := s.backupChain(ctx, manifest)
+	if err != nil {
+		return err
+	}
+
+	workDir, err := os.MkdirTemp(filepath.Dir(dataDir), ".pg-backup-combine-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	var args []string
+	for i, m := range chain {
+		dir := filepath.Join(workDir, strconv.Itoa(i))
+		if err := s.extractPhysical(ctx, m, RestoreOptions{DataDirectory: dir}); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", m.Name, err)
+		}
+		args = append(args, dir)
+	}
+	args = append(args, "-o", dataDir)
+
+	// 表空间按最后一个备份的位置重新映射到目标目录
+	last := args[len(chain)-1]
+	for _, oid := range tablespaceOIDs(manifest) {
+		target := opts.TablespaceMapping[oid]
+		if target == "" {
+			target = filepath.Join(dataDir+"_tblspc", oid)
+		}
+		args = append(args, "-T", filepath.Join(last+"_tblspc", oid)+"="+target)
+	}
+
+	cmd := exec.CommandContext(ctx, "pg_combinebackup", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_combinebackup failed: %v, stderr: %s", err, stderr.String())
+	}
+	return os.Chmod(dataDir, 0700)
+}
+
+// tablespaceOIDs 返回物理备份中各表空间的 OID
+func tablespaceOIDs(manifest *Manifest) []string {
+	algorithm := CompressionNone
+	if manifest.Compression != nil {
+		algorithm = manifest.Compression.Algorithm
+	}
+
+	var oids []string
+	for _, file := range manifest.Files {
+		name := strings.TrimSuffix(file.Name, compressionExt(algorithm))
+		if name != "base.tar" && name != "pg_wal.tar" && strings.HasSuffix(name, ".tar") {
+			oids = append(oids, strings.TrimSuffix(name, ".tar"))
+		}
+	}
+	return oids
+}
+
+// fillBackupChains 为增量备份填充从完整备份开始的备份链
+func (s *Service) fillBackupChains(records []BackupRecord) error {
+	rows, err := s.db.Query("SELECT id, parent_id FROM backup_records WHERE parent_id IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	parents := make(map[int64]int64)
+	for rows.Next() {
+		var id, parentID int64
+		if err := rows.Scan(&id, &parentID); err != nil {
+			return err
+		}
+		parents[id] = parentID
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range records {
+		if records[i].ParentID == 0 {
+			continue
+		}
+		chain := []int64{records[i].ID}
+		for id, ok := records[i].ParentID, true; ok; id, ok = parents[id] {
+			chain = append([]int64{id}, chain...)
+		}
+		records[i].Chain = chain
+	}
+	return nil
+}
+
+// countChildBackups 统计依赖该备份的增量备份，失败的不计入
+func (s *Service) countChildBackups(ctx context.Context, id int64) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM backup_records WHERE parent_id = $1 AND status <> 'failed'
+	`, id).Scan(&n)
+	return n, err
+}
+
+// deleteBackupFiles 删除备份在存储中的文件和清单：物理备份的各个文件、逻辑备份的分卷或单个文件
+func (s *Service) deleteBackupFiles(ctx context.Context, manifest *Manifest) {
+	keys := make([]string, 0, len(manifest.Files)+len(manifest.Volumes)+1)
+	for _, file := range manifest.Files {
+		keys = append(keys, file.Key)
+	}
+	for _, vol := range manifest.Volumes {
+		keys = append(keys, vol.Key)
+	}
+	if len(keys) == 0 {
+		keys = append(keys, manifest.Key)
+	}
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete %s: %v", key, err)
+		}
+	}
+	if err := s.storage.Delete(ctx, manifestKey(manifest.Key)); err != nil {
+		log.Printf("Failed to delete manifest of %s: %v", manifest.Name, err)
+	}
+}
+
+// expirePhysicalBackups 按保留天数删除旧的物理备份，固定的备份和仍有增量备份依赖的父备份会被保留
+func (s *Service) expirePhysicalBackups() {
+	ctx := context.Background()
+	cutoff := time.Now().AddDate(0, 0, -s.config.Storage.Local.Retention)
+
+	// 删除子备份后其父备份可能变为可删除，重复直到没有可删除的备份
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT b.id FROM backup_records b
+			WHERE b.kind IN ($1, $2) AND b.status <> 'running' AND b.timestamp < $3 AND NOT `+pinActive+`
+			  AND NOT EXISTS (
+			      SELECT 1 FROM backup_records c WHERE c.parent_id = b.id AND c.status <> 'failed'
+			  )
+		`, KindPhysical, KindIncremental, cutoff)
+		if err != nil {
+			log.Printf("Failed to query expired physical backups: %v", err)
+			return
+		}
+
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+
+		deleted := 0
+		for _, id := range ids {
+			if err := s.DeleteBackup(id); err != nil {
+				log.Printf("Failed to delete expired backup %d: %v", id, err)
+				continue
+			}
+			deleted++
+		}
+		if deleted == 0 {
+			return
+		}
+	}
+}
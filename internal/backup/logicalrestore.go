@@ -0,0 +1,495 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"pg-backup/internal/config"
+)
+
+// ObjectSelection 选择性恢复的对象，需要 custom 格式的备份
+type ObjectSelection struct {
+	Schemas []string `json:"schemas,omitempty"` // 恢复整个 schema
+	Tables  []string `json:"tables,omitempty"`  // schema.table，未写 schema 时为 public
+	// DataOnly 只恢复数据，表结构需已存在
+	DataOnly bool `json:"dataOnly,omitempty"`
+	// TargetSchema 恢复到旁路 schema 而不是原 schema，便于与现有数据比对。
+	// 默认值、外键、触发器和策略可能引用原 schema 中的对象，不会恢复。
+	TargetSchema string `json:"targetSchema,omitempty"`
+}
+
+// hostResolveTimeout 生产库检查中解析主机名的超时
+const hostResolveTimeout = 5 * time.Second
+
+var plainIdentPattern = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+
+// sideSchemaSkipped 恢复到旁路 schema 时跳过的对象类型
+var sideSchemaSkipped = map[string]bool{
+	"DEFAULT":           true,
+	"FK CONSTRAINT":     true,
+	"TRIGGER":           true,
+	"POLICY":            true,
+	"ROW SECURITY":      true,
+	"SEQUENCE OWNED BY": true,
+	"ACL":               true,
+}
+
+// GetBackupContents 列出 custom 格式备份中的对象
+func (s *Service) GetBackupContents(ctx context.Context, id int64) (*ArchiveContents, error) {
+	manifest, err := s.loadManifest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil || manifest.Format != FormatCustom {
+		return nil, fmt.Errorf("backup %d is not a custom-format dump", id)
+	}
+
+	pgRestore, err := s.selectPgRestore(manifest, nil)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := s.fetchLogicalBackup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(archive)
+
+	entries, err := listArchive(ctx, pgRestore, archive)
+	if err != nil {
+		return nil, err
+	}
+	contents := buildContents(entries)
+	contents.BackupID = id
+	return &contents, nil
+}
+
+// restoreLogical 恢复逻辑备份：plain 格式交给 psql 执行，custom 格式交给 pg_restore，可只恢复选中的对象。
+// 指定脱敏配置时脱敏脚本与恢复在同一个事务中执行，脱敏失败时恢复一并回滚，不会留下未脱敏的数据。
+func (s *Service) restoreLogical(ctx context.Context, id int64, manifest *Manifest, opts RestoreOptions) error {
+	format := manifest.Format
+	if format == "" {
+		format = FormatPlain
+	}
+	if opts.Objects != nil && format != FormatCustom {
+		return fmt.Errorf("selective restore requires a custom-format backup")
+	}
+
+	conn, err := s.serverConn(opts.Server, opts.Database)
+	if err != nil {
+		return err
+	}
+	var profile *MaskingProfile
+	if opts.MaskingProfile != "" {
+		if s.isProduction(opts.Server, conn) {
+			return fmt.Errorf("masking requires a non-production target database")
+		}
+		if profile, err = s.loadMaskingProfile(ctx, opts.MaskingProfile); err != nil {
+			return err
+		}
+	}
+
+	var pgRestore string
+	if format == FormatCustom {
+		if pgRestore, err = s.selectPgRestore(manifest, &conn); err != nil {
+			return err
+		}
+	}
+
+	archive, err := s.fetchLogicalBackup(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive)
+
+	var selected []ArchiveEntry
+	if opts.Objects != nil {
+		entries, err := listArchive(ctx, pgRestore, archive)
+		if err != nil {
+			return err
+		}
+		if selected, err = opts.Objects.filter(entries); err != nil {
+			return err
+		}
+	}
+
+	// after 为恢复之后在同一事务中执行的 SQL 文件。选择性恢复时只对恢复了的表脱敏，
+	// 恢复到旁路 schema 时规则作用于该 schema
+	var after []string
+	if profile != nil {
+		rules, schema := profile.Rules, ""
+		if opts.Objects != nil {
+			rules, schema = restoredRules(profile.Rules, selected), opts.Objects.TargetSchema
+		}
+		if len(rules) > 0 {
+			maskFile := archive + ".masking.sql"
+			if err := os.WriteFile(maskFile, []byte(maskingScript(rules, schema)), 0600); err != nil {
+				return err
+			}
+			defer os.Remove(maskFile)
+			after = append(after, maskFile)
+		}
+	}
+
+	switch {
+	case format == FormatPlain:
+		err = s.runRestoreCommand(ctx, conn, "psql", append(connArgs(conn),
+			append([]string{"-v", "ON_ERROR_STOP=1", "--single-transaction", "-f", archive}, fileArgs(after)...)...), nil)
+	case opts.Objects != nil:
+		err = s.restoreObjects(ctx, pgRestore, archive, conn, *opts.Objects, selected, after)
+	case len(after) > 0:
+		err = s.pipeRestore(ctx, conn, pgRestore, []string{archive}, copySQL, after)
+	default:
+		err = s.runRestoreCommand(ctx, conn, pgRestore, append(connArgs(conn),
+			"--single-transaction", "--exit-on-error", archive), nil)
+	}
+	if profile == nil {
+		return err
+	}
+	return s.auditMasking(ctx, profile, opts.Server, conn, id, err)
+}
+
+// restoreObjects 按 sel 选出的 TOC 条目生成对象清单，只恢复这些对象，after 为同一事务中随后执行的 SQL 文件
+func (s *Service) restoreObjects(ctx context.Context, pgRestore, archive string, conn config.DatabaseConfig, sel ObjectSelection,
+	selected []ArchiveEntry, after []string) error {
+	var list bytes.Buffer
+	for _, e := range selected {
+		list.WriteString(e.line + "\n")
+	}
+	listFile := archive + ".list"
+	if err := os.WriteFile(listFile, list.Bytes(), 0600); err != nil {
+		return err
+	}
+	defer os.Remove(listFile)
+
+	if sel.TargetSchema == "" {
+		if len(after) > 0 {
+			return s.pipeRestore(ctx, conn, pgRestore, []string{"-L", listFile, archive}, copySQL, after)
+		}
+		return s.runRestoreCommand(ctx, conn, pgRestore, append(connArgs(conn),
+			"--single-transaction", "--exit-on-error", "-L", listFile, archive), nil)
+	}
+
+	// 旁路 schema：pg_restore 输出 SQL，改写 schema 名后交给 psql 执行
+	var sources []string
+	for _, e := range selected {
+		if e.Schema != "" && !containsString(sources, e.Schema) {
+			sources = append(sources, e.Schema)
+		}
+	}
+	return s.pipeRestore(ctx, conn, pgRestore, []string{"--no-owner", "--no-privileges", "-L", listFile, archive},
+		func(r io.Reader, w io.Writer) error {
+			fmt.Fprintf(w, "CREATE SCHEMA IF NOT EXISTS %s;\n", quoteIdent(sel.TargetSchema))
+			return rewriteSchemas(r, w, sources, sel.TargetSchema)
+		}, after)
+}
+
+// pipeRestore 将 pg_restore 输出的 SQL 经 rewrite 处理后交给 psql 在一个事务中执行，
+// after 中的 SQL 文件在同一事务中随后执行
+func (s *Service) pipeRestore(ctx context.Context, conn config.DatabaseConfig, pgRestore string, restoreArgs []string,
+	rewrite func(io.Reader, io.Writer) error, after []string) error {
+	restore := exec.CommandContext(ctx, pgRestore, append([]string{"-f", "-"}, restoreArgs...)...)
+	var restoreErr bytes.Buffer
+	restore.Stderr = &restoreErr
+	out, err := restore.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := restore.Start(); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(rewrite(out, pw))
+		// psql 提前退出时读完剩余输出，避免 pg_restore 阻塞
+		io.Copy(io.Discard, out)
+	}()
+
+	err = s.runRestoreCommand(ctx, conn, "psql", append(connArgs(conn),
+		append([]string{"-v", "ON_ERROR_STOP=1", "--single-transaction", "-f", "-"}, fileArgs(after)...)...), pr)
+	pr.Close()
+	<-done
+	if werr := restore.Wait(); werr != nil && err == nil {
+		err = fmt.Errorf("pg_restore failed: %v, stderr: %s", werr, restoreErr.String())
+	}
+	return err
+}
+
+// copySQL 原样输出 pg_restore 生成的 SQL
+func copySQL(r io.Reader, w io.Writer) error {
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// fileArgs 依次执行 SQL 文件的 psql 参数
+func fileArgs(files []string) []string {
+	var args []string
+	for _, f := range files {
+		args = append(args, "-f", f)
+	}
+	return args
+}
+
+// filter 选出要恢复的 TOC 条目，保持原有顺序
+func (sel ObjectSelection) filter(entries []ArchiveEntry) ([]ArchiveEntry, error) {
+	if len(sel.Schemas) == 0 && len(sel.Tables) == 0 {
+		return nil, fmt.Errorf("no schemas or tables selected")
+	}
+	tables := make(map[string]bool)
+	for _, t := range sel.Tables {
+		schema, table, ok := strings.Cut(t, ".")
+		if !ok {
+			schema, table = "public", t
+		}
+		tables[schema+"."+table] = true
+	}
+
+	var selected []ArchiveEntry
+	for _, e := range entries {
+		wholeSchema := containsString(sel.Schemas, e.Schema)
+		if e.Type == "SCHEMA" && containsString(sel.Schemas, e.Name) {
+			if !sel.DataOnly && sel.TargetSchema == "" {
+				selected = append(selected, e)
+			}
+			continue
+		}
+		if !wholeSchema && !tables[e.Schema+"."+e.tableOf()] {
+			continue
+		}
+		switch {
+		case sel.DataOnly && e.Type != "TABLE DATA" && e.Type != "SEQUENCE SET":
+			continue
+		case sel.TargetSchema != "" && sideSchemaSkipped[e.Type]:
+			continue
+		}
+		selected = append(selected, e)
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no objects in the backup match the selection")
+	}
+	return selected, nil
+}
+
+// restoredRules 只保留作用于已恢复表的脱敏规则，其他表不在目标库中，对其 UPDATE 会使整个恢复回滚
+func restoredRules(rules []MaskingRule, selected []ArchiveEntry) []MaskingRule {
+	tables := make(map[string]bool)
+	for _, e := range selected {
+		if e.Type == "TABLE" || e.Type == "TABLE DATA" {
+			tables[e.Schema+"."+e.Name] = true
+		}
+	}
+
+	var kept []MaskingRule
+	for _, r := range rules {
+		schema, table, ok := strings.Cut(r.Table, ".")
+		if !ok {
+			schema, table = "public", r.Table
+		}
+		if tables[schema+"."+table] {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// rewriteSchemas 将 SQL 中对 sources 的 schema 引用替换为 target，COPY 数据行原样输出
+func rewriteSchemas(r io.Reader, w io.Writer, sources []string, target string) error {
+	if len(sources) == 0 {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	var names []string
+	for _, src := range sources {
+		names = append(names, regexp.QuoteMeta(quoteIdent(src)))
+		if quoteIdent(src) != src {
+			continue
+		}
+		names = append(names, regexp.QuoteMeta(strconv.Quote(src)))
+	}
+	pattern := regexp.MustCompile(`(^|[^\w".$])(` + strings.Join(names, "|") + `)\.`)
+	replacement := "${1}" + strings.ReplaceAll(quoteIdent(target), "$", "$$") + "."
+
+	br := bufio.NewReader(r)
+	inCopy := false
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			switch {
+			case inCopy:
+				inCopy = line != "\\.\n"
+			default:
+				if strings.HasPrefix(line, "COPY ") && strings.HasSuffix(line, "FROM stdin;\n") {
+					inCopy = true
+				}
+				line = pattern.ReplaceAllString(line, replacement)
+			}
+			if _, werr := io.WriteString(w, line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// fetchLogicalBackup 下载并解压逻辑备份到临时文件
+func (s *Service) fetchLogicalBackup(ctx context.Context, id int64) (string, error) {
+	rc, filename, err := s.DownloadBackup(ctx, id, 0, true)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp("", "restore-*-"+filepath.Base(filename))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, rc); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// serverConn 返回恢复目标的连接参数，server 为空时为备份来源服务器，database 为空时为备份来源数据库
+func (s *Service) serverConn(server, database string) (config.DatabaseConfig, error) {
+	conn := s.config.Database
+	if server != "" {
+		srv := s.config.Server(server)
+		if srv == nil {
+			return conn, fmt.Errorf("server %s is not configured", server)
+		}
+		conn = config.DatabaseConfig{
+			Host:     srv.Host,
+			Port:     srv.Port,
+			Username: srv.Username,
+			Password: srv.Password,
+		}
+	}
+	if database != "" {
+		conn.Database = database
+	}
+	if conn.Database == "" {
+		return conn, fmt.Errorf("database is required")
+	}
+	return conn, nil
+}
+
+// isProduction 目标是备份来源库、来源的备库或标记为生产的服务器时返回 true。
+// 主机按解析出的地址比较，localhost 与 127.0.0.1、DNS 别名都视为同一主机。
+func (s *Service) isProduction(server string, conn config.DatabaseConfig) bool {
+	if srv := s.config.Server(server); srv != nil && srv.Production {
+		return true
+	}
+	src := s.config.Database
+	if conn.Port == src.Port && conn.Database == src.Database && sameHost(conn.Host, src.Host) {
+		return true
+	}
+	replica := src.Replica
+	return replica != nil && conn.Port == replica.Port && sameHost(conn.Host, replica.Host)
+}
+
+// sameHost 判断两个主机名是否指向同一主机：名称相同或解析出的地址有交集
+func sameHost(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	addrsA, addrsB := resolveHost(a), resolveHost(b)
+	for _, x := range addrsA {
+		for _, y := range addrsB {
+			if x.Equal(y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveHost 解析主机地址，回环地址和 Unix socket 目录统一为 127.0.0.1，无法解析时返回 nil
+func resolveHost(host string) []net.IP {
+	if host == "" || strings.HasPrefix(host, "/") {
+		return []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), hostResolveTimeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for i, ip := range ips {
+		if ip.IsLoopback() {
+			ips[i] = net.IPv4(127, 0, 0, 1)
+		}
+	}
+	return ips
+}
+
+// connArgs 连接目标数据库的公共参数
+func connArgs(conn config.DatabaseConfig) []string {
+	return []string{
+		"-h", conn.Host,
+		"-p", strconv.Itoa(conn.Port),
+		"-U", conn.Username,
+		"-d", conn.Database,
+	}
+}
+
+func (s *Service) runRestoreCommand(ctx context.Context, conn config.DatabaseConfig, name string, args []string, stdin io.Reader) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v, stderr: %s", filepath.Base(name), err, stderr.String())
+	}
+	return nil
+}
+
+// quoteIdent 按 PostgreSQL 规则为标识符加引号，普通小写标识符原样返回
+func quoteIdent(name string) string {
+	if plainIdentPattern.MatchString(name) {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
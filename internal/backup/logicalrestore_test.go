@@ -0,0 +1,200 @@
+package backup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestObjectSelectionFilter(t *testing.T) {
+	entries := parseTOC([]byte(sampleTOC))
+	tests := []struct {
+		name    string
+		sel     ObjectSelection
+		want    []int
+		wantErr bool
+	}{
+		{
+			name: "table with related objects",
+			sel:  ObjectSelection{Tables: []string{"app.users"}},
+			want: []int{218, 3001, 3010, 3030, 3040, 3050, 3060},
+		},
+		{
+			name: "table without schema is public",
+			sel:  ObjectSelection{Tables: []string{"events"}},
+			want: []int{220},
+		},
+		{
+			name: "whole schema",
+			sel:  ObjectSelection{Schemas: []string{"app"}},
+			want: []int{5, 218, 219, 3001, 3010, 3020, 3030, 3040, 3050, 3060},
+		},
+		{
+			name: "data only",
+			sel:  ObjectSelection{Schemas: []string{"app"}, DataOnly: true},
+			want: []int{3030},
+		},
+		{
+			name: "side schema skips dependent objects",
+			sel:  ObjectSelection{Tables: []string{"app.users"}, TargetSchema: "app_restore"},
+			want: []int{218, 3010, 3030, 3040},
+		},
+		{
+			name:    "nothing selected",
+			sel:     ObjectSelection{},
+			wantErr: true,
+		},
+		{
+			name:    "no match",
+			sel:     ObjectSelection{Tables: []string{"app.missing"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := tt.sel.filter(entries)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", entryIDs(selected))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := entryIDs(selected); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteSchemas(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []string
+		target  string
+		in      string
+		want    string
+	}{
+		{
+			name:    "plain and quoted references",
+			sources: []string{"app"},
+			target:  "app_restore",
+			in: "CREATE TABLE app.users (id integer);\n" +
+				"ALTER TABLE ONLY \"app\".users ADD CONSTRAINT users_pkey PRIMARY KEY (id);\n" +
+				"SELECT myapp.f(), pg_catalog.set_config('search_path', '', false);\n",
+			want: "CREATE TABLE app_restore.users (id integer);\n" +
+				"ALTER TABLE ONLY app_restore.users ADD CONSTRAINT users_pkey PRIMARY KEY (id);\n" +
+				"SELECT myapp.f(), pg_catalog.set_config('search_path', '', false);\n",
+		},
+		{
+			name:    "copy data is left untouched",
+			sources: []string{"app"},
+			target:  "side",
+			in: "COPY app.users (id, email) FROM stdin;\n" +
+				"1\tuser@app.example\n" +
+				"2\tapp.users\n" +
+				"\\.\n" +
+				"CREATE INDEX users_email_idx ON app.users USING btree (email);\n",
+			want: "COPY side.users (id, email) FROM stdin;\n" +
+				"1\tuser@app.example\n" +
+				"2\tapp.users\n" +
+				"\\.\n" +
+				"CREATE INDEX users_email_idx ON side.users USING btree (email);\n",
+		},
+		{
+			name:    "quoted source and target",
+			sources: []string{"My Schema"},
+			target:  "Restore$1",
+			in:      "CREATE TABLE \"My Schema\".t (id integer);\n",
+			want:    "CREATE TABLE \"Restore$1\".t (id integer);\n",
+		},
+		{
+			name:    "several sources",
+			sources: []string{"a", "b"},
+			target:  "c",
+			in:      "CREATE VIEW a.v AS SELECT * FROM b.t",
+			want:    "CREATE VIEW c.v AS SELECT * FROM c.t",
+		},
+		{
+			name:   "no sources",
+			target: "side",
+			in:     "CREATE TABLE app.users (id integer);\n",
+			want:   "CREATE TABLE app.users (id integer);\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := rewriteSchemas(strings.NewReader(tt.in), &out, tt.sources, tt.target); err != nil {
+				t.Fatalf("rewriteSchemas: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Fatalf("rewriteSchemas() =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoredRulesSelectiveRestore(t *testing.T) {
+	rules := []MaskingRule{
+		{Table: "app.users", Column: "email", Strategy: MaskEmail},
+		{Table: "app.orders", Column: "note", Strategy: MaskNull},
+		{Table: "events", Column: "payload", Strategy: MaskNull},
+	}
+	entries := parseTOC([]byte(sampleTOC))
+
+	tests := []struct {
+		name   string
+		sel    ObjectSelection
+		want   []MaskingRule
+		script string
+	}{
+		{
+			name: "only restored tables",
+			sel:  ObjectSelection{Tables: []string{"app.users"}},
+			want: rules[:1],
+			script: "UPDATE app.users SET email = CASE WHEN email IS NULL THEN NULL " +
+				"ELSE 'user_' || substr(md5(email::text), 1, 12) || '@example.invalid' END;\n",
+		},
+		{
+			name: "target schema",
+			sel:  ObjectSelection{Tables: []string{"app.users"}, TargetSchema: "app_restore"},
+			want: rules[:1],
+			script: "UPDATE app_restore.users SET email = CASE WHEN email IS NULL THEN NULL " +
+				"ELSE 'user_' || substr(md5(email::text), 1, 12) || '@example.invalid' END;\n",
+		},
+		{
+			name:   "table without schema is public",
+			sel:    ObjectSelection{Tables: []string{"public.events"}},
+			want:   rules[2:],
+			script: "UPDATE public.events SET payload = NULL;\n",
+		},
+		{
+			// orders 不在备份中，它的规则不能出现在脚本里
+			name:   "whole schema",
+			sel:    ObjectSelection{Schemas: []string{"app"}, DataOnly: true},
+			want:   rules[:1],
+			script: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := tt.sel.filter(entries)
+			if err != nil {
+				t.Fatalf("filter: %v", err)
+			}
+			got := restoredRules(rules, selected)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("restoredRules() = %+v, want %+v", got, tt.want)
+			}
+			if tt.script == "" {
+				return
+			}
+			if script := maskingScript(got, tt.sel.TargetSchema); script != tt.script {
+				t.Fatalf("maskingScript() =\n%s\nwant\n%s", script, tt.script)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"pg-backup/internal/config"
+	"pg-backup/internal/storage"
+)
+
+const manifestVersion = 1
+
+// Manifest 描述一个备份产物的组成和校验信息
+type Manifest struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+
+	// Compression 数据的压缩方式，下载和恢复时据此解压
+	Compression *config.CompressionConfig `json:"compression,omitempty"`
+	// Format 逻辑备份的 pg_dump 格式，为空表示 plain
+	Format string `json:"format,omitempty"`
+	// DataOnly 逻辑备份不含 schema，无法用于 schema 比较
+	DataOnly bool `json:"dataOnly,omitempty"`
+	// Versions 备份时服务器和 pg_dump 的版本
+	Versions *ToolVersions `json:"versions,omitempty"`
+
+	Volumes []Volume `json:"volumes,omitempty"`
+
+	// Files 物理备份由多个 tar 文件组成，Physical 记录其 WAL 位置
+	Files    []BackupFile  `json:"files,omitempty"`
+	Physical *PhysicalInfo `json:"physical,omitempty"`
+
+	// Repository 仓库模式下数据以去重块的形式存放，Key 指向块索引
+	Repository *RepositoryInfo `json:"repository,omitempty"`
+}
+
+// ToolVersions 备份时使用的版本，恢复时据此选择 pg_restore
+type ToolVersions struct {
+	Server      string `json:"server"`
+	PgDump      string `json:"pgDump,omitempty"`
+	PgDumpMajor int    `json:"pgDumpMajor,omitempty"`
+	PgDumpPath  string `json:"pgDumpPath,omitempty"`
+}
+
+// Volume 分卷信息，按 Index 顺序拼接即为完整的备份文件
+type Volume struct {
+	Index  int    `json:"index"`
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// manifestKey 清单文件与备份数据存放在一起，便于脱离数据库恢复
+func manifestKey(key string) string {
+	return key + ".manifest.json"
+}
+
+// saveManifest 将清单写入备份记录并作为附属文件上传到存储
+func (s *Service) saveManifest(ctx context.Context, id int64, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx, "UPDATE backup_records SET manifest = $1, size_bytes = $2 WHERE id = $3",
+		string(data), manifest.Size, id); err != nil {
+		return err
+	}
+
+	return s.storage.Upload(ctx, manifestKey(manifest.Key), bytes.NewReader(data), int64(len(data)), storage.TransferOptions{})
+}
+
+// loadManifest 读取备份记录中的清单，旧备份没有清单时返回 nil
+func (s *Service) loadManifest(ctx context.Context, id int64) (*Manifest, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, "SELECT manifest FROM backup_records WHERE id = $1", id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("backup %d not found", id)
+	}
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest for backup %d: %w", id, err)
+	}
+	return &manifest, nil
+}
+
+// fileChecksum 计算文件的 SHA-256
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyBackup 下载备份的原始数据，按清单校验 SHA-256
+func (s *Service) verifyBackup(ctx context.Context, id int64) error {
+	manifest, err := s.loadManifest(ctx, id)
+	if err != nil {
+		return err
+	}
+	if manifest == nil || manifest.SHA256 == "" {
+		return fmt.Errorf("backup %d has no checksum to verify", id)
+	}
+
+	rc, _, err := s.DownloadBackup(ctx, id, 0, false)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != manifest.SHA256 {
+		return fmt.Errorf("backup %d checksum mismatch: expected %s, got %s", id, manifest.SHA256, sum)
+	}
+	return nil
+}
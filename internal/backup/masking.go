@@ -0,0 +1,289 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"pg-backup/internal/config"
+)
+
+// 脱敏方式
+const (
+	MaskHash     = "hash"     // md5 摘要，适用于文本列
+	MaskEmail    = "email"    // 由原值摘要生成的假邮箱，保持唯一性
+	MaskNull     = "null"     // 置为 NULL
+	MaskConstant = "constant" // 替换为固定值
+	MaskShuffle  = "shuffle"  // 在行之间随机打乱，保留值的分布
+)
+
+// MaskingRule 单个列的脱敏规则
+type MaskingRule struct {
+	Table    string  `json:"table"` // schema.table，未写 schema 时为 public
+	Column   string  `json:"column"`
+	Strategy string  `json:"strategy"`
+	Value    *string `json:"value,omitempty"` // constant 使用的值
+}
+
+// MaskingProfile 脱敏配置，恢复生产备份到非生产库时按规则清洗数据
+type MaskingProfile struct {
+	ID        int64         `json:"id"`
+	Name      string        `json:"name" binding:"required"`
+	Rules     []MaskingRule `json:"rules" binding:"required"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// MaskingAudit 脱敏执行记录
+type MaskingAudit struct {
+	ID        int64     `json:"id"`
+	ProfileID int64     `json:"profileId"`
+	Profile   string    `json:"profile"`
+	BackupID  int64     `json:"backupId,omitempty"`
+	Server    string    `json:"server,omitempty"`
+	Database  string    `json:"database"`
+	Rules     int       `json:"rules"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+// validate 检查规则是否完整
+func (p *MaskingProfile) validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("profile name is required")
+	}
+	if len(p.Rules) == 0 {
+		return fmt.Errorf("profile %s has no rules", p.Name)
+	}
+	seen := make(map[string]bool)
+	for _, r := range p.Rules {
+		if r.Table == "" || r.Column == "" {
+			return fmt.Errorf("masking rule requires table and column")
+		}
+		key := r.qualifiedTable("") + "." + r.Column
+		if seen[key] {
+			return fmt.Errorf("column %s has more than one masking rule", key)
+		}
+		seen[key] = true
+
+		switch r.Strategy {
+		case MaskHash, MaskEmail, MaskNull, MaskShuffle:
+		case MaskConstant:
+			if r.Value == nil {
+				return fmt.Errorf("constant masking of %s requires a value", key)
+			}
+		default:
+			return fmt.Errorf("unsupported masking strategy %q for %s", r.Strategy, key)
+		}
+	}
+	return nil
+}
+
+// qualifiedTable 返回带引号的表名，schema 不为空时替换规则中的 schema（用于恢复到旁路 schema）
+func (r MaskingRule) qualifiedTable(schema string) string {
+	src, table, ok := strings.Cut(r.Table, ".")
+	if !ok {
+		src, table = "public", r.Table
+	}
+	if schema != "" {
+		src = schema
+	}
+	return quoteIdent(src) + "." + quoteIdent(table)
+}
+
+// maskingSQL 生成脱敏语句：同一张表的普通规则合并为一条 UPDATE，shuffle 单独执行
+func maskingSQL(rules []MaskingRule, schema string) []string {
+	var tables []string
+	sets := make(map[string][]string)
+	var shuffles []string
+
+	for _, r := range rules {
+		table := r.qualifiedTable(schema)
+		col := quoteIdent(r.Column)
+		var expr string
+		switch r.Strategy {
+		case MaskHash:
+			expr = fmt.Sprintf("md5(%s::text)", col)
+		case MaskEmail:
+			expr = fmt.Sprintf("'user_' || substr(md5(%s::text), 1, 12) || '@example.invalid'", col)
+		case MaskNull:
+			expr = "NULL"
+		case MaskConstant:
+			expr = quoteLiteral(*r.Value)
+		case MaskShuffle:
+			shuffles = append(shuffles, fmt.Sprintf(`WITH src AS (
+    SELECT %[2]s AS v, row_number() OVER (ORDER BY random()) AS rn FROM %[1]s
+), dst AS (
+    SELECT ctid AS row_id, row_number() OVER (ORDER BY random()) AS rn FROM %[1]s
+)
+UPDATE %[1]s AS t SET %[2]s = src.v
+FROM dst JOIN src USING (rn)
+WHERE t.ctid = dst.row_id;`, table, col))
+			continue
+		}
+		if r.Strategy != MaskNull && r.Strategy != MaskConstant {
+			// 保留 NULL，避免把空值变成看似真实的数据
+			expr = fmt.Sprintf("CASE WHEN %s IS NULL THEN NULL ELSE %s END", col, expr)
+		}
+		if _, ok := sets[table]; !ok {
+			tables = append(tables, table)
+		}
+		sets[table] = append(sets[table], fmt.Sprintf("%s = %s", col, expr))
+	}
+
+	var stmts []string
+	for _, table := range tables {
+		stmts = append(stmts, fmt.Sprintf("UPDATE %s SET %s;", table, strings.Join(sets[table], ", ")))
+	}
+	return append(stmts, shuffles...)
+}
+
+// quoteLiteral 生成 SQL 字符串常量
+func quoteLiteral(v string) string {
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
+
+// CreateMaskingProfile 保存脱敏配置
+func (s *Service) CreateMaskingProfile(ctx context.Context, p *MaskingProfile) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	rules, err := json.Marshal(p.Rules)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO masking_profiles (name, rules) VALUES ($1, $2)
+		RETURNING id, created_at
+	`, p.Name, string(rules)).Scan(&p.ID, &p.CreatedAt)
+}
+
+// GetMaskingProfiles 列出所有脱敏配置
+func (s *Service) GetMaskingProfiles(ctx context.Context) ([]MaskingProfile, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, rules, created_at FROM masking_profiles ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []MaskingProfile
+	for rows.Next() {
+		var p MaskingProfile
+		var rules []byte
+		if err := rows.Scan(&p.ID, &p.Name, &rules, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(rules, &p.Rules); err != nil {
+			return nil, fmt.Errorf("invalid rules in masking profile %s: %w", p.Name, err)
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+// DeleteMaskingProfile 删除脱敏配置，已有的执行记录保留配置名称
+func (s *Service) DeleteMaskingProfile(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM masking_profiles WHERE id = $1", id)
+	return err
+}
+
+func (s *Service) loadMaskingProfile(ctx context.Context, name string) (*MaskingProfile, error) {
+	var p MaskingProfile
+	var rules []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, name, rules, created_at FROM masking_profiles WHERE name = $1
+	`, name).Scan(&p.ID, &p.Name, &rules, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("masking profile %s not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(rules, &p.Rules); err != nil {
+		return nil, fmt.Errorf("invalid rules in masking profile %s: %w", name, err)
+	}
+	return &p, nil
+}
+
+// ApplyMasking 对已恢复的数据库执行脱敏配置（恢复后的独立 SQL 阶段），
+// server 为空时为备份来源服务器，backupID 为 0 表示不关联备份
+func (s *Service) ApplyMasking(ctx context.Context, profileName, server, database string, backupID int64) error {
+	if database == "" {
+		return fmt.Errorf("database is required for masking")
+	}
+	conn, err := s.serverConn(server, database)
+	if err != nil {
+		return err
+	}
+	return s.applyMasking(ctx, profileName, server, conn, "", backupID)
+}
+
+// applyMasking 在一个事务中执行所有规则并写入审计记录。schema 不为空时规则作用于该 schema。
+func (s *Service) applyMasking(ctx context.Context, profileName, server string, conn config.DatabaseConfig, schema string, backupID int64) error {
+	// 脱敏会改写数据，禁止作用于生产库
+	if s.isProduction(server, conn) {
+		return fmt.Errorf("refusing to mask the production database %s", conn.Database)
+	}
+
+	profile, err := s.loadMaskingProfile(ctx, profileName)
+	if err != nil {
+		return err
+	}
+
+	err = s.runRestoreCommand(ctx, conn, "psql", append(connArgs(conn),
+		"-v", "ON_ERROR_STOP=1", "--single-transaction", "-f", "-"), strings.NewReader(maskingScript(profile.Rules, schema)))
+	return s.auditMasking(ctx, profile, server, conn, backupID, err)
+}
+
+// maskingScript 脱敏规则对应的完整 SQL 脚本
+func maskingScript(rules []MaskingRule, schema string) string {
+	return strings.Join(maskingSQL(rules, schema), "\n") + "\n"
+}
+
+// auditMasking 写入脱敏执行记录，err 为脱敏的执行结果，原样返回
+func (s *Service) auditMasking(ctx context.Context, profile *MaskingProfile, server string, conn config.DatabaseConfig, backupID int64, err error) error {
+	status, errMsg := "completed", ""
+	if err != nil {
+		status, errMsg = "failed", err.Error()
+	}
+	var backup sql.NullInt64
+	if backupID != 0 {
+		backup = sql.NullInt64{Int64: backupID, Valid: true}
+	}
+	if _, aerr := s.db.ExecContext(ctx, `
+		INSERT INTO masking_audit (profile_id, profile_name, backup_id, server, database, rules, status, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, profile.ID, profile.Name, backup, server, conn.Database, len(profile.Rules), status, errMsg); aerr != nil && err == nil {
+		err = fmt.Errorf("masking applied but audit record failed: %w", aerr)
+	}
+	return err
+}
+
+// GetMaskingAudit 返回最近的脱敏执行记录
+func (s *Service) GetMaskingAudit(ctx context.Context) ([]MaskingAudit, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, COALESCE(profile_id, 0), profile_name, COALESCE(backup_id, 0), COALESCE(server, ''), database, rules, status,
+		       COALESCE(error, ''), applied_at
+		FROM masking_audit
+		ORDER BY applied_at DESC
+		LIMIT 100
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []MaskingAudit
+	for rows.Next() {
+		var a MaskingAudit
+		if err := rows.Scan(&a.ID, &a.ProfileID, &a.Profile, &a.BackupID, &a.Server, &a.Database, &a.Rules,
+			&a.Status, &a.Error, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, a)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"database/sql"
+	"fmt"
+
+	"pg-backup/internal/config"
+	"pg-backup/pkg/utils"
+)
+
+// Options 单次备份的参数
+type Options struct {
+	// Kind 备份种类：logical（默认，pg_dump）、physical（pg_basebackup）或 incremental（基于父备份的增量物理备份）
+	Kind string `json:"kind,omitempty"`
+	// Database 逻辑备份的数据库，为空时使用配置中的数据库
+	Database string `json:"database,omitempty"`
+	// Destination 备份的存储类型（local 或 s3），为空时使用配置的存储，与配置不同时拒绝备份
+	Destination string `json:"destination,omitempty"`
+	// Physical 物理备份参数
+	Physical *PhysicalOptions `json:"physical,omitempty"`
+
+	IncludeData   bool `json:"includeData"`
+	IncludeSchema bool `json:"includeSchema"`
+	Compression   bool `json:"compression"`
+
+	// Selection 逻辑备份的 schema/表/扩展范围，为空时备份整个数据库
+	Selection *Selection `json:"selection,omitempty"`
+	// Format 逻辑备份格式：plain（默认，SQL 文本）或 custom（pg_dump -Fc，支持按对象恢复）
+	Format string `json:"format,omitempty"`
+
+	// Compress 指定压缩算法、级别和方式，为空时 Compression 为 true 使用配置中的压缩设置
+	Compress *config.CompressionConfig `json:"compress,omitempty"`
+
+	// UploadLimit 覆盖存储配置中的上传限速（字节/秒）：0 使用配置值，负数表示不限速
+	UploadLimit int64 `json:"uploadLimit,omitempty"`
+	// VolumeSize 覆盖存储配置中的分卷大小（字节）：0 使用配置值，负数表示不分卷
+	VolumeSize int64 `json:"volumeSize,omitempty"`
+	// Repository 写入去重仓库，存储配置开启仓库模式时总是写入
+	Repository bool `json:"repository,omitempty"`
+	// Dump 覆盖配置中 pg_dump 的 nice/ionice 设置，为空时使用配置值
+	Dump *config.DumpConfig `json:"dump,omitempty"`
+	// Hooks 备份前后执行的 SQL、shell 命令或 HTTP 请求
+	Hooks []Hook `json:"hooks,omitempty"`
+	// SkipPreflight 跳过备份前的预检
+	SkipPreflight bool `json:"skipPreflight,omitempty"`
+	// Labels、Notes 写入备份记录的标签和备注
+	Labels []string `json:"labels,omitempty"`
+	Notes  string   `json:"notes,omitempty"`
+
+	// JobID 由定时任务触发时为任务 ID，写入备份记录；OnRecord 在备份记录创建后以记录 ID 调用
+	JobID    int64          `json:"-"`
+	OnRecord func(id int64) `json:"-"`
+
+	// 一致性组中的备份：snapshot 为导出的快照，group 为所属的组
+	snapshot string
+	group    int64
+
+	// pgDump 按服务器版本选择的 pg_dump
+	pgDump *ClientBinary
+}
+
+// 逻辑备份格式
+const (
+	FormatPlain  = "plain"
+	FormatCustom = "custom"
+)
+
+// dumpFormat 返回本次逻辑备份的格式
+func (o Options) dumpFormat() string {
+	if o.Format == "" {
+		return FormatPlain
+	}
+	return o.Format
+}
+
+// dumpExt 返回逻辑备份格式对应的文件扩展名
+func dumpExt(format string) string {
+	if format == FormatCustom {
+		return ".dump"
+	}
+	return ".sql"
+}
+
+// pgDumpName 返回本次备份使用的 pg_dump，未选择时为 PATH 中的 pg_dump
+func (o Options) pgDumpName() string {
+	if o.pgDump != nil {
+		return o.pgDump.Path
+	}
+	return "pg_dump"
+}
+
+// primaryConn 返回本次备份在主库上的连接参数
+func (s *Service) primaryConn(opts Options) config.DatabaseConfig {
+	conn := s.config.Database
+	conn.Replica = nil
+	if opts.Database != "" {
+		conn.Database = opts.Database
+	}
+	return conn
+}
+
+// pinnedToPrimary 一致性组的备份使用主库导出的快照，只能在主库上执行
+func (o Options) pinnedToPrimary() bool {
+	return o.snapshot != ""
+}
+
+// openDatabase 打开到 conn 的短时连接，调用方负责关闭
+func openDatabase(conn config.DatabaseConfig) (*sql.DB, error) {
+	return utils.OpenDatabase(utils.DBConfig{
+		Host:     conn.Host,
+		Port:     conn.Port,
+		User:     conn.Username,
+		Password: conn.Password,
+		DBName:   conn.Database,
+	})
+}
+
+// ValidateOptions 校验备份参数，并检查目标存储与当前配置一致
+func (s *Service) ValidateOptions(opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if opts.Destination != "" && opts.Destination != s.config.Storage.Type {
+		return fmt.Errorf("destination %s is not the configured storage (%s)", opts.Destination, s.config.Storage.Type)
+	}
+	return nil
+}
+
+// dumpConfig 返回本次备份实际生效的进程优先级配置
+func (s *Service) dumpConfig(opts Options) config.DumpConfig {
+	if opts.Dump != nil {
+		return *opts.Dump
+	}
+	return s.config.Dump
+}
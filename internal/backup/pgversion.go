@@ -0,0 +1,194 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pg-backup/internal/config"
+)
+
+var clientVersionPattern = regexp.MustCompile(`\(PostgreSQL\) (\d+)(?:\.(\d+))?`)
+
+// defaultClientPaths 多版本安装时客户端工具所在的目录（Debian/Ubuntu、RHEL、源码安装），在 PATH 之前搜索
+var defaultClientPaths = []string{
+	"/usr/lib/postgresql/*/bin",
+	"/usr/pgsql-*/bin",
+	"/usr/local/pgsql/bin",
+}
+
+// ClientBinary 已安装的 PostgreSQL 客户端工具
+type ClientBinary struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Major   int    `json:"major"`
+}
+
+var (
+	clientCacheMu sync.Mutex
+	clientCache   = make(map[string]*ClientBinary)
+)
+
+// clientMajorVersion 通过 --version 获取 PostgreSQL 客户端工具的主版本号
+func clientMajorVersion(binary string) (int, error) {
+	client, err := clientVersion(binary)
+	if err != nil {
+		return 0, err
+	}
+	return client.Major, nil
+}
+
+// clientVersion 通过 --version 获取客户端工具的完整版本，结果按路径缓存
+func clientVersion(binary string) (*ClientBinary, error) {
+	clientCacheMu.Lock()
+	defer clientCacheMu.Unlock()
+	if client, ok := clientCache[binary]; ok {
+		return client, nil
+	}
+
+	out, err := exec.Command(binary, "--version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s --version: %w", binary, err)
+	}
+
+	m := clientVersionPattern.FindSubmatch(out)
+	if m == nil {
+		return nil, fmt.Errorf("unrecognized %s version output: %s", binary, out)
+	}
+	major, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return nil, err
+	}
+	client := &ClientBinary{Path: binary, Version: string(m[1]), Major: major}
+	if len(m[2]) > 0 {
+		client.Version += "." + string(m[2])
+	}
+	clientCache[binary] = client
+	return client, nil
+}
+
+// installedClients 在配置的目录、默认目录和 PATH 中查找客户端工具，按主版本升序，同一主版本只保留先找到的
+func (s *Service) installedClients(name string) []*ClientBinary {
+	var candidates []string
+	for _, pattern := range append(append([]string{}, s.config.ClientPaths...), defaultClientPaths...) {
+		dirs, _ := filepath.Glob(pattern)
+		for _, dir := range dirs {
+			candidates = append(candidates, filepath.Join(dir, name))
+		}
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		candidates = append(candidates, path)
+	}
+
+	seen := make(map[int]bool)
+	var clients []*ClientBinary
+	for _, path := range candidates {
+		client, err := clientVersion(path)
+		if err != nil || seen[client.Major] {
+			continue
+		}
+		seen[client.Major] = true
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Major < clients[j].Major })
+	return clients
+}
+
+// findClient 选择主版本与 minMajor 相同的客户端，没有时选择更新的版本中最旧的一个
+func (s *Service) findClient(name string, minMajor int) (*ClientBinary, error) {
+	clients := s.installedClients(name)
+	for _, client := range clients {
+		if client.Major >= minMajor {
+			return client, nil
+		}
+	}
+	return nil, noClientError(name, minMajor, clients)
+}
+
+// newestClient 选择最新的客户端（新版 pg_restore 可以读取旧版 pg_dump 的归档），版本低于 minMajor 时报错
+func (s *Service) newestClient(name string, minMajor int) (*ClientBinary, error) {
+	clients := s.installedClients(name)
+	if len(clients) == 0 || clients[len(clients)-1].Major < minMajor {
+		return nil, noClientError(name, minMajor, clients)
+	}
+	return clients[len(clients)-1], nil
+}
+
+func noClientError(name string, minMajor int, clients []*ClientBinary) error {
+	if len(clients) == 0 {
+		return fmt.Errorf("%s not found in PATH or %s", name, strings.Join(defaultClientPaths, ", "))
+	}
+	var installed []string
+	for _, c := range clients {
+		installed = append(installed, fmt.Sprintf("%s (%s)", c.Version, c.Path))
+	}
+	return fmt.Errorf("no %s %d or later is installed, found: %s", name, minMajor, strings.Join(installed, ", "))
+}
+
+// selectPgDump 为备份选择与服务器版本匹配的 pg_dump，并检查其是否支持所用的过滤参数
+func (s *Service) selectPgDump(opts *Options, source config.DatabaseConfig) (*ToolVersions, error) {
+	version, major, err := serverVersion(source)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.findClient("pg_dump", major)
+	if err != nil {
+		return nil, fmt.Errorf("server %s:%d runs PostgreSQL %s: %w", source.Host, source.Port, version, err)
+	}
+	if err := opts.Selection.checkClientSupport(client.Major); err != nil {
+		return nil, err
+	}
+	opts.pgDump = client
+	return &ToolVersions{Server: version, PgDump: client.Version, PgDumpMajor: client.Major, PgDumpPath: client.Path}, nil
+}
+
+// selectPgRestore 选择能读取备份归档的 pg_restore，conn 不为空时还需不低于恢复目标服务器的版本
+func (s *Service) selectPgRestore(manifest *Manifest, conn *config.DatabaseConfig) (string, error) {
+	minMajor := 0
+	if manifest != nil && manifest.Versions != nil {
+		minMajor = manifest.Versions.PgDumpMajor
+	}
+	if conn != nil {
+		_, major, err := serverVersion(*conn)
+		if err != nil {
+			return "", err
+		}
+		if major > minMajor {
+			minMajor = major
+		}
+	}
+	client, err := s.newestClient("pg_restore", minMajor)
+	if err != nil {
+		return "", err
+	}
+	return client.Path, nil
+}
+
+// serverVersion 查询服务器的版本号和主版本
+func serverVersion(conn config.DatabaseConfig) (string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	db, err := openDatabase(conn)
+	if err != nil {
+		return "", 0, err
+	}
+	defer db.Close()
+
+	var version string
+	var num int
+	err = db.QueryRowContext(ctx, "SELECT current_setting('server_version'), current_setting('server_version_num')::int").
+		Scan(&version, &num)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to query server version of %s:%d: %w", conn.Host, conn.Port, err)
+	}
+	version, _, _ = strings.Cut(version, " ")
+	return version, num / 10000, nil
+}
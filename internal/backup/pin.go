@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pinActive 备份处于固定状态的条件：已固定且未过期。保留策略和删除都跳过这些备份。
+const pinActive = "(pinned_at IS NOT NULL AND (pin_expires_at IS NULL OR pin_expires_at > CURRENT_TIMESTAMP))"
+
+// PinRequest 固定备份的参数
+type PinRequest struct {
+	Reason string `json:"reason" binding:"required"`
+	// By 固定备份的人
+	By string `json:"by"`
+	// ExpiresAt 固定的截止时间，为空表示永久保留
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// BackupPin 备份的固定信息
+type BackupPin struct {
+	By        string     `json:"by"`
+	Reason    string     `json:"reason"`
+	PinnedAt  time.Time  `json:"pinnedAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Expired 固定已过期，备份重新受保留策略管理
+	Expired bool `json:"expired,omitempty"`
+}
+
+// PinBackup 固定备份，使其不受保留策略清理；已固定的备份会更新原因和截止时间
+func (s *Service) PinBackup(ctx context.Context, id int64, req PinRequest) (*BackupPin, error) {
+	if strings.TrimSpace(req.Reason) == "" {
+		return nil, fmt.Errorf("reason is required")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("expiresAt must be in the future")
+	}
+
+	pin := &BackupPin{By: req.By, Reason: req.Reason, ExpiresAt: req.ExpiresAt}
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE backup_records
+		SET pinned_at = CURRENT_TIMESTAMP, pinned_by = $1, pin_reason = $2, pin_expires_at = $3
+		WHERE id = $4 AND status <> 'failed'
+		RETURNING pinned_at
+	`, req.By, req.Reason, req.ExpiresAt, id).Scan(&pin.PinnedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("backup %d not found or failed", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pin, nil
+}
+
+// UnpinBackup 取消固定，备份重新受保留策略管理
+func (s *Service) UnpinBackup(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE backup_records
+		SET pinned_at = NULL, pinned_by = NULL, pin_reason = NULL, pin_expires_at = NULL
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("backup %d not found", id)
+	}
+	return nil
+}
+
+// isPinned 检查备份是否处于固定状态
+func (s *Service) isPinned(ctx context.Context, id int64) (bool, error) {
+	var pinned bool
+	err := s.db.QueryRowContext(ctx, "SELECT "+pinActive+" FROM backup_records WHERE id = $1", id).Scan(&pinned)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return pinned, err
+}
+
+// pinnedBackupNames 返回处于固定状态的备份名称，按文件清理本地备份时据此跳过
+func (s *Service) pinnedBackupNames(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM backup_records WHERE "+pinActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// scanPin 由查询出的固定列构造 BackupPin，未固定时返回 nil
+func scanPin(pinnedAt sql.NullTime, by, reason string, expiresAt sql.NullTime) *BackupPin {
+	if !pinnedAt.Valid {
+		return nil
+	}
+	pin := &BackupPin{By: by, Reason: reason, PinnedAt: pinnedAt.Time}
+	if expiresAt.Valid {
+		pin.ExpiresAt = &expiresAt.Time
+		pin.Expired = !expiresAt.Time.After(time.Now())
+	}
+	return pin
+}
@@ -0,0 +1,235 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecoveryTarget 时间点恢复的目标，Time、LSN、Name 最多指定一个，都为空时恢复到归档末尾
+type RecoveryTarget struct {
+	Time      string `json:"time,omitempty"`      // RFC3339 时间
+	LSN       string `json:"lsn,omitempty"`       // 例如 0/3000060
+	Name      string `json:"name,omitempty"`      // pg_create_restore_point 创建的还原点
+	Timeline  string `json:"timeline,omitempty"`  // latest、current 或时间线编号
+	Inclusive *bool  `json:"inclusive,omitempty"` // 是否包含目标本身
+	Action    string `json:"action,omitempty"`    // 到达目标后的动作：pause、promote、shutdown
+}
+
+// PITROptions 时间点恢复参数
+type PITROptions struct {
+	// BackupID 指定基础备份，为 0 时自动选择目标之前最近的物理备份。
+	// 还原点的位置无法预先确定，此时使用时间线上最早的物理备份，以保证能回放到还原点。
+	BackupID          int64             `json:"backupId"`
+	DataDirectory     string            `json:"dataDirectory" binding:"required"`
+	TablespaceMapping map[string]string `json:"tablespaceMapping,omitempty"`
+	Target            RecoveryTarget    `json:"target"`
+}
+
+// RestorePointInTime 从基础备份加上 WAL 归档重建数据目录，启动后由 restore_command 回放到目标位置。
+// 需要 PostgreSQL 12 及以上版本（recovery.signal）。返回使用的基础备份 ID。
+func (s *Service) RestorePointInTime(ctx context.Context, opts PITROptions) (int64, error) {
+	target := opts.Target
+	if err := validateRecoveryTarget(&target); err != nil {
+		return 0, err
+	}
+
+	baseID := opts.BackupID
+	if baseID == 0 {
+		var err error
+		if baseID, err = s.findBaseBackup(ctx, target); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := s.Restore(ctx, baseID, RestoreOptions{
+		DataDirectory:     opts.DataDirectory,
+		TablespaceMapping: opts.TablespaceMapping,
+	}); err != nil {
+		return baseID, err
+	}
+
+	return baseID, s.writeRecoveryConfig(opts.DataDirectory, target)
+}
+
+func validateRecoveryTarget(t *RecoveryTarget) error {
+	set := 0
+	for _, v := range []string{t.Time, t.LSN, t.Name} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("only one of time, lsn and name can be specified as recovery target")
+	}
+	if t.Time != "" {
+		if _, err := time.Parse(time.RFC3339, t.Time); err != nil {
+			return fmt.Errorf("invalid recovery target time: %w", err)
+		}
+	}
+	if t.LSN != "" {
+		if _, err := parseLSN(t.LSN); err != nil {
+			return err
+		}
+	}
+	if t.Action == "" {
+		t.Action = "promote"
+	}
+	switch t.Action {
+	case "pause", "promote", "shutdown":
+	default:
+		return fmt.Errorf("invalid recovery target action: %s", t.Action)
+	}
+	return nil
+}
+
+// findBaseBackup 选择在恢复目标之前完成的最近一次物理备份。还原点只记录在 WAL 中，
+// 无法判断哪些备份在其之前，因此选择时间线上最早的物理备份。
+func (s *Service) findBaseBackup(ctx context.Context, target RecoveryTarget) (int64, error) {
+	if target.Name != "" {
+		return s.oldestBaseBackup(ctx, target.Timeline)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, COALESCE(stop_lsn, ''), completed_at
+		FROM backup_records
+		WHERE kind IN ($1, $2) AND status = 'completed' AND completed_at IS NOT NULL
+		ORDER BY completed_at DESC
+	`, KindPhysical, KindIncremental)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var targetTime time.Time
+	if target.Time != "" {
+		targetTime, _ = time.Parse(time.RFC3339, target.Time)
+	}
+	var targetLSN uint64
+	if target.LSN != "" {
+		targetLSN, _ = parseLSN(target.LSN)
+	}
+
+	for rows.Next() {
+		var id int64
+		var stopLSN string
+		var completedAt time.Time
+		if err := rows.Scan(&id, &stopLSN, &completedAt); err != nil {
+			return 0, err
+		}
+
+		switch {
+		case target.Time != "":
+			if completedAt.After(targetTime) {
+				continue
+			}
+		case target.LSN != "":
+			lsn, err := parseLSN(stopLSN)
+			if err != nil || lsn > targetLSN {
+				continue
+			}
+		}
+		return id, nil
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no physical backup found before the recovery target")
+}
+
+// oldestBaseBackup 返回时间线上最早完成的物理备份。timeline 为编号时使用该时间线，
+// 否则使用最近一次物理备份所在的时间线。
+func (s *Service) oldestBaseBackup(ctx context.Context, timeline string) (int64, error) {
+	var tli sql.NullInt64
+	if n, err := strconv.ParseInt(timeline, 10, 64); err == nil {
+		tli = sql.NullInt64{Int64: n, Valid: true}
+	}
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id FROM backup_records
+		WHERE kind IN ($1, $2) AND status = 'completed' AND completed_at IS NOT NULL
+		  AND COALESCE(timeline, 0) = COALESCE($3, (
+		      SELECT COALESCE(timeline, 0) FROM backup_records
+		      WHERE kind IN ($1, $2) AND status = 'completed' AND completed_at IS NOT NULL
+		      ORDER BY completed_at DESC
+		      LIMIT 1))
+		ORDER BY completed_at
+		LIMIT 1
+	`, KindPhysical, KindIncremental, tli).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no physical backup found for the recovery target")
+	}
+	return id, err
+}
+
+// writeRecoveryConfig 写入 recovery.signal 和恢复参数
+func (s *Service) writeRecoveryConfig(dataDir string, target RecoveryTarget) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	restoreCommand := fmt.Sprintf("'%s' restore-wal", exe)
+	if s.config.Path() != "" {
+		// restore_command 在数据目录下执行，配置路径需为绝对路径
+		configPath, err := filepath.Abs(s.config.Path())
+		if err != nil {
+			return err
+		}
+		restoreCommand += fmt.Sprintf(" -config '%s'", configPath)
+	}
+	restoreCommand += " %f %p"
+
+	settings := []string{
+		"# added by pg-backup point-in-time recovery",
+		fmt.Sprintf("restore_command = '%s'", escapeConfValue(restoreCommand)),
+		fmt.Sprintf("recovery_target_action = '%s'", target.Action),
+	}
+	switch {
+	case target.Time != "":
+		settings = append(settings, fmt.Sprintf("recovery_target_time = '%s'", escapeConfValue(target.Time)))
+	case target.LSN != "":
+		settings = append(settings, fmt.Sprintf("recovery_target_lsn = '%s'", escapeConfValue(target.LSN)))
+	case target.Name != "":
+		settings = append(settings, fmt.Sprintf("recovery_target_name = '%s'", escapeConfValue(target.Name)))
+	}
+	if target.Timeline != "" {
+		settings = append(settings, fmt.Sprintf("recovery_target_timeline = '%s'", escapeConfValue(target.Timeline)))
+	}
+	if target.Inclusive != nil {
+		settings = append(settings, fmt.Sprintf("recovery_target_inclusive = %t", *target.Inclusive))
+	}
+
+	conf, err := os.OpenFile(filepath.Join(dataDir, "postgresql.auto.conf"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := conf.WriteString("\n" + strings.Join(settings, "\n") + "\n"); err != nil {
+		conf.Close()
+		return err
+	}
+	if err := conf.Close(); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dataDir, "recovery.signal"), nil, 0600)
+}
+
+// escapeConfValue 转义 postgresql.conf 字符串中的单引号
+func escapeConfValue(v string) string {
+	return strings.ReplaceAll(v, "'", "''")
+}
+
+// parseLSN 将 X/Y 形式的 LSN 转为整数便于比较
+func parseLSN(lsn string) (uint64, error) {
+	var hi, lo uint32
+	if _, err := fmt.Sscanf(lsn, "%X/%X", &hi, &lo); err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", lsn)
+	}
+	return uint64(hi)<<32 | uint64(lo), nil
+}
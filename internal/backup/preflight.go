@@ -0,0 +1,256 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"pg-backup/internal/config"
+)
+
+// 预检结果
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// PreflightCheck 单项预检
+type PreflightCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// PreflightReport 备份前的预检报告，Status 为所有检查中最差的结果
+type PreflightReport struct {
+	Status string `json:"status"`
+	// EstimatedBytes 由 pg_database_size 估计的备份大小上限
+	EstimatedBytes int64            `json:"estimatedBytes"`
+	Checks         []PreflightCheck `json:"checks"`
+}
+
+func (r *PreflightReport) add(name, status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, PreflightCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+	if status == CheckFail || (status == CheckWarn && r.Status == CheckPass) {
+		r.Status = status
+	}
+}
+
+// failures 汇总失败的检查，用于备份失败时的错误信息
+func (r *PreflightReport) failures() string {
+	var msgs []string
+	for _, c := range r.Checks {
+		if c.Status == CheckFail {
+			msgs = append(msgs, c.Name+": "+c.Message)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// String 生成写入运行日志的文本
+func (r *PreflightReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "preflight %s\n", r.Status)
+	for _, c := range r.Checks {
+		fmt.Fprintf(&b, "  [%s] %s: %s\n", c.Status, c.Name, c.Message)
+	}
+	return b.String()
+}
+
+// Preflight 检查目标的连通性和权限、估算备份大小、检查临时目录和本地备份目录的剩余空间、
+// 验证存储可写并确认 pg_dump 版本
+func (s *Service) Preflight(ctx context.Context, opts Options) *PreflightReport {
+	report := &PreflightReport{Status: CheckPass}
+	if err := s.ValidateOptions(opts); err != nil {
+		report.add("options", CheckFail, "%v", err)
+		return report
+	}
+	physical := opts.Kind == KindPhysical || opts.Kind == KindIncremental
+	conn := s.primaryConn(opts)
+
+	s.checkConnection(ctx, report, conn, opts, physical)
+	if report.Status == CheckFail {
+		return report
+	}
+	// 一致性组的备份使用主库导出的快照，总在主库上执行，不检查备库
+	if replica := s.config.Database.Replica; replica != nil && !opts.pinnedToPrimary() {
+		rc := replicaConn(conn, replica)
+		if lag, err := checkReplica(rc, replica); err != nil {
+			status := CheckWarn
+			if replica.Fallback == ReplicaFallbackFail {
+				status = CheckFail
+			}
+			report.add("replica", status, "%s:%d is not usable: %v", rc.Host, rc.Port, err)
+		} else {
+			report.add("replica", CheckPass, "%s:%d lag %s", rc.Host, rc.Port, lag)
+		}
+	}
+
+	if physical && s.streamsBasebackup(opts, conn) {
+		report.add("temp space", CheckPass, "pg_basebackup output is streamed to storage")
+	} else {
+		s.checkSpace(report, "temp space", os.TempDir(), opts, physical)
+	}
+	if s.config.Storage.Type == "local" {
+		s.checkSpace(report, "backup path space", s.config.Storage.Local.BackupPath, opts, physical)
+	}
+
+	storage := s.storage.TestConnection(ctx)
+	if storage.Success {
+		report.add("storage", CheckPass, "%s is writable", storage.Target)
+	} else {
+		var errs []string
+		for _, step := range storage.Steps {
+			if !step.OK {
+				errs = append(errs, fmt.Sprintf("%s: %s", step.Name, step.Error))
+			}
+		}
+		report.add("storage", CheckFail, "%s is not writable: %s", storage.Target, strings.Join(errs, "; "))
+	}
+
+	if physical {
+		if client, err := clientVersion("pg_basebackup"); err != nil {
+			report.add("client", CheckFail, "%v", err)
+		} else {
+			report.add("client", CheckPass, "pg_basebackup %s (%s)", client.Version, client.Path)
+		}
+	} else {
+		dumpOpts := opts
+		if versions, err := s.selectPgDump(&dumpOpts, conn); err != nil {
+			report.add("client", CheckFail, "%v", err)
+		} else {
+			report.add("client", CheckPass, "pg_dump %s (%s) for server %s", versions.PgDump, versions.PgDumpPath, versions.Server)
+		}
+	}
+	return report
+}
+
+// runPreflight 备份开始前的预检阶段，报告写入运行日志，有失败项时中止备份
+func (s *Service) runPreflight(id int64, opts Options) error {
+	if opts.SkipPreflight {
+		return nil
+	}
+	report := s.Preflight(context.Background(), opts)
+	s.appendRunLog(id, report.String())
+	if report.Status == CheckFail {
+		return fmt.Errorf("preflight failed: %s", report.failures())
+	}
+	return nil
+}
+
+// checkConnection 连接目标并检查权限：逻辑备份需要读取备份范围内的所有表，物理备份需要复制权限
+func (s *Service) checkConnection(ctx context.Context, report *PreflightReport, conn config.DatabaseConfig, opts Options, physical bool) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	db, err := openDatabase(conn)
+	if err != nil {
+		report.add("connection", CheckFail, "%v", err)
+		return
+	}
+	defer db.Close()
+
+	var user string
+	var superuser, replication bool
+	err = db.QueryRowContext(ctx, `
+		SELECT current_user, rolsuper, rolreplication FROM pg_roles WHERE rolname = current_user
+	`).Scan(&user, &superuser, &replication)
+	if err != nil {
+		report.add("connection", CheckFail, "cannot connect to %s:%d/%s: %v", conn.Host, conn.Port, conn.Database, err)
+		return
+	}
+	report.add("connection", CheckPass, "connected to %s:%d/%s as %s", conn.Host, conn.Port, conn.Database, user)
+
+	// 物理备份复制整个集群，按所有数据库的大小估计
+	sizeQuery, what := "SELECT pg_database_size(current_database())", "database"
+	if physical {
+		sizeQuery, what = "SELECT SUM(pg_database_size(oid))::bigint FROM pg_database", "cluster"
+	}
+	if err := db.QueryRowContext(ctx, sizeQuery).Scan(&report.EstimatedBytes); err != nil {
+		report.add("size", CheckWarn, "cannot estimate %s size: %v", what, err)
+	} else {
+		report.add("size", CheckPass, "%s size %s", what, formatFileSize(report.EstimatedBytes))
+	}
+
+	if physical {
+		if superuser || replication {
+			report.add("permissions", CheckPass, "%s has the replication privilege", user)
+		} else {
+			report.add("permissions", CheckFail, "%s lacks the REPLICATION privilege required by pg_basebackup", user)
+		}
+		return
+	}
+
+	filter, args := opts.Selection.tableFilter()
+	if filter != "" {
+		filter = "AND " + filter
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT n.nspname || '.' || c.relname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'm', 'S')
+		  AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		  AND n.nspname NOT LIKE 'pg_toast%'
+		  AND NOT has_table_privilege(c.oid, 'SELECT')
+		  `+filter+`
+		ORDER BY 1
+	`, args...)
+	if err != nil {
+		report.add("permissions", CheckWarn, "cannot check table privileges: %v", err)
+		return
+	}
+	defer rows.Close()
+	var denied []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			denied = append(denied, name)
+		}
+	}
+	switch {
+	case len(denied) == 0:
+		report.add("permissions", CheckPass, "%s can read all tables in the backup", user)
+	case len(denied) > 5:
+		report.add("permissions", CheckFail, "%s cannot read %d tables, including %s", user, len(denied), strings.Join(denied[:5], ", "))
+	default:
+		report.add("permissions", CheckFail, "%s cannot read %s", user, strings.Join(denied, ", "))
+	}
+}
+
+// streamsBasebackup 物理备份是否直接流式上传，不占用临时目录
+func (s *Service) streamsBasebackup(opts Options, conn config.DatabaseConfig) bool {
+	physical := PhysicalOptions{}
+	if opts.Physical != nil {
+		physical = *opts.Physical
+	}
+	if validatePhysicalOptions(&physical) != nil {
+		return false
+	}
+	cluster, err := inspectCluster(conn)
+	return err == nil && cluster.streamable(physical)
+}
+
+// checkSpace 比较目录剩余空间与估计的备份大小。未压缩或物理备份按估计大小要求，
+// 压缩的逻辑备份通常远小于数据库，空间不足时只给出警告。
+func (s *Service) checkSpace(report *PreflightReport, name, dir string, opts Options, physical bool) {
+	free, err := freeSpace(dir)
+	if err != nil {
+		report.add(name, CheckWarn, "cannot check free space in %s: %v", dir, err)
+		return
+	}
+	switch {
+	case report.EstimatedBytes == 0 || free >= report.EstimatedBytes:
+		report.add(name, CheckPass, "%s free in %s", formatFileSize(free), dir)
+	case physical:
+		report.add(name, CheckFail, "%s free in %s, cluster is %s", formatFileSize(free), dir, formatFileSize(report.EstimatedBytes))
+	case !(opts.Compression || opts.Compress != nil || s.useRepository(opts)):
+		report.add(name, CheckFail, "%s free in %s, database is %s", formatFileSize(free), dir, formatFileSize(report.EstimatedBytes))
+	default:
+		report.add(name, CheckWarn, "%s free in %s, database is %s (compressed dump may still fit)",
+			formatFileSize(free), dir, formatFileSize(report.EstimatedBytes))
+	}
+}
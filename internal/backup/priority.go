@@ -0,0 +1,36 @@
+package backup
+
+import (
+	"log"
+	"os/exec"
+	"strconv"
+
+	"pg-backup/internal/config"
+)
+
+// withProcessPriority 按配置在命令前加上 nice/ionice，系统中缺少对应工具时跳过
+func withProcessPriority(cfg config.DumpConfig, name string, args []string) (string, []string) {
+	if cfg.IONiceClass > 0 {
+		if _, err := exec.LookPath("ionice"); err == nil {
+			prefix := []string{"-c", strconv.Itoa(cfg.IONiceClass)}
+			if cfg.IONiceClass != 3 {
+				prefix = append(prefix, "-n", strconv.Itoa(cfg.IONiceLevel))
+			}
+			args = append(append(prefix, name), args...)
+			name = "ionice"
+		} else {
+			log.Printf("ionice not found, running %s without I/O priority", name)
+		}
+	}
+
+	if cfg.Nice != 0 {
+		if _, err := exec.LookPath("nice"); err == nil {
+			args = append([]string{"-n", strconv.Itoa(cfg.Nice), name}, args...)
+			name = "nice"
+		} else {
+			log.Printf("nice not found, running %s without CPU priority", name)
+		}
+	}
+
+	return name, args
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"time"
+
+	"pg-backup/internal/storage"
+)
+
+// 备份/下载的执行阶段
+const (
+	PhaseDumping     = "dumping"
+	PhaseVerifying   = "verifying"
+	PhaseUploading   = "uploading"
+	PhaseDownloading = "downloading"
+)
+
+// Progress 正在执行的备份或下载的进度
+type Progress struct {
+	BackupID    int64     `json:"backupId"`
+	Phase       string    `json:"phase"`
+	TotalBytes  int64     `json:"totalBytes"`
+	Bytes       int64     `json:"bytes"`
+	RateBps     int64     `json:"rateBps"`
+	LimitBps    int64     `json:"limitBps"`
+	Nice        int       `json:"nice,omitempty"`
+	IONiceClass int       `json:"ioniceClass,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+}
+
+// progressEntry 内部进度状态，传输字节数实时从 TransferStats 读取
+type progressEntry struct {
+	Progress
+	transfer *storage.TransferStats
+}
+
+func (s *Service) trackProgress(id int64, phase string) *progressEntry {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+
+	entry := &progressEntry{Progress: Progress{
+		BackupID:  id,
+		Phase:     phase,
+		StartedAt: time.Now(),
+	}}
+	s.progress[entry] = struct{}{}
+	return entry
+}
+
+func (s *Service) setPhase(entry *progressEntry, phase string, totalBytes int64) *storage.TransferStats {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+
+	entry.Phase = phase
+	entry.TotalBytes = totalBytes
+	entry.transfer = &storage.TransferStats{}
+	return entry.transfer
+}
+
+func (s *Service) untrackProgress(entry *progressEntry) {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	delete(s.progress, entry)
+}
+
+// GetProgress 返回所有正在执行的备份和下载的进度
+func (s *Service) GetProgress() []Progress {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+
+	result := make([]Progress, 0, len(s.progress))
+	for entry := range s.progress {
+		p := entry.Progress
+		if entry.transfer != nil {
+			p.Bytes = entry.transfer.Bytes()
+			p.RateBps = entry.transfer.Rate()
+			p.LimitBps = entry.transfer.Limit()
+		}
+		result = append(result, p)
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"pg-backup/internal/config"
+)
+
+const (
+	defaultReplicaMaxLag = 300 * time.Second
+	replicaCheckTimeout  = 10 * time.Second
+
+	ReplicaFallbackPrimary = "primary"
+	ReplicaFallbackFail    = "fail"
+)
+
+// selectSource 选择备份的来源：配置了备库且备库处于恢复状态、延迟不超过阈值时使用备库，
+// 否则按 fallback 策略回退到主库或返回错误。实际使用的主机写入备份记录和运行日志。
+func (s *Service) selectSource(id int64, primary config.DatabaseConfig, useReplica bool) (config.DatabaseConfig, error) {
+	source := primary
+	if replica := s.config.Database.Replica; replica != nil && useReplica {
+		conn := replicaConn(primary, replica)
+		lag, err := checkReplica(conn, replica)
+		if err == nil {
+			s.appendRunLog(id, fmt.Sprintf("using replica %s:%d (lag %s)", conn.Host, conn.Port, lag))
+			source = conn
+		} else {
+			if replica.Fallback == ReplicaFallbackFail {
+				return primary, fmt.Errorf("replica %s:%d is not usable: %w", conn.Host, conn.Port, err)
+			}
+			log.Printf("Backup %d: replica %s:%d is not usable, falling back to primary: %v", id, conn.Host, conn.Port, err)
+			s.appendRunLog(id, fmt.Sprintf("replica %s:%d is not usable, falling back to primary: %v", conn.Host, conn.Port, err))
+		}
+	}
+
+	if _, err := s.db.Exec("UPDATE backup_records SET source_host = $1 WHERE id = $2",
+		fmt.Sprintf("%s:%d", source.Host, source.Port), id); err != nil {
+		log.Printf("Failed to record source host of backup %d: %v", id, err)
+	}
+	return source, nil
+}
+
+// replicaConn 备库的连接参数，未配置的用户名和密码沿用主库
+func replicaConn(primary config.DatabaseConfig, replica *config.ReplicaConfig) config.DatabaseConfig {
+	conn := primary
+	conn.Host, conn.Port = replica.Host, replica.Port
+	if replica.Username != "" {
+		conn.Username, conn.Password = replica.Username, replica.Password
+	}
+	return conn
+}
+
+// checkReplica 检查备库处于恢复状态、WAL 接收进程正在流复制且回放延迟不超过阈值，返回当前延迟。
+// 已回放完所有接收到的 WAL 时延迟视为 0，避免主库空闲时误判；因此必须确认仍在接收，
+// 否则与主库断开的备库回放完已接收的 WAL 后也会显示为 0 延迟。
+// 没有 pg_read_all_stats 权限的角色只能看到 pg_stat_wal_receiver 的 pid，此时以接收进程存在为准。
+func checkReplica(conn config.DatabaseConfig, replica *config.ReplicaConfig) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+	defer cancel()
+
+	db, err := openDatabase(conn)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var inRecovery, streaming bool
+	var lagSeconds float64
+	err = db.QueryRowContext(ctx, `
+		SELECT pg_is_in_recovery(),
+		       EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE pid IS NOT NULL AND COALESCE(status, 'streaming') = 'streaming'),
+		       CASE WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		            ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		       END
+	`).Scan(&inRecovery, &streaming, &lagSeconds)
+	if err != nil {
+		return 0, err
+	}
+	if !inRecovery {
+		return 0, fmt.Errorf("server is not in recovery")
+	}
+	if !streaming {
+		return 0, fmt.Errorf("WAL receiver is not streaming from the primary")
+	}
+
+	maxLag := defaultReplicaMaxLag
+	if replica.MaxLagSeconds > 0 {
+		maxLag = time.Duration(replica.MaxLagSeconds) * time.Second
+	}
+	lag := time.Duration(lagSeconds * float64(time.Second)).Round(time.Second)
+	if lag > maxLag {
+		return lag, fmt.Errorf("replication lag %s exceeds %s", lag, maxLag)
+	}
+	return lag, nil
+}
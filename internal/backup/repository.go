@@ -0,0 +1,234 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"pg-backup/internal/repository"
+	"pg-backup/internal/storage"
+)
+
+// repositoryPrefix 去重仓库在存储中的根路径
+const repositoryPrefix = "repository"
+
+// RepositoryInfo 仓库模式备份在清单中的信息
+type RepositoryInfo struct {
+	Index       string `json:"index"`
+	TotalChunks int    `json:"totalChunks"`
+	NewChunks   int    `json:"newChunks"`
+	NewBytes    int64  `json:"newBytes"`
+}
+
+// RepositoryStats 仓库的逻辑大小与实际占用
+type RepositoryStats struct {
+	Backups     int     `json:"backups"`
+	Chunks      int     `json:"chunks"`
+	LogicalSize int64   `json:"logicalSize"`
+	RealSize    int64   `json:"realSize"`
+	DedupRatio  float64 `json:"dedupRatio"`
+}
+
+// useRepository 判断本次备份是否写入去重仓库
+func (s *Service) useRepository(opts Options) bool {
+	return opts.Repository || s.config.Storage.Repository
+}
+
+// storeInRepository 将 dump 文件切块写入仓库。仓库锁同时作用于本进程和共享存储的其他进程，释放之前先把索引记录到备份记录的清单中，
+// 避免并发的 prune 在最终清单保存前回收新备份引用的块。
+func (s *Service) storeInRepository(ctx context.Context, recordID int64, sourceFile, name string, stats *storage.TransferStats) (*Manifest, error) {
+	file, err := os.Open(sourceFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	s.repoMu.Lock()
+	defer s.repoMu.Unlock()
+	lock, err := s.repo.LockWrite(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	index, writeStats, err := s.repo.Write(ctx, name, storage.NewMeteredReader(ctx, file, 0, stats))
+	if err != nil {
+		return nil, err
+	}
+
+	sum, err := fileChecksum(sourceFile)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{
+		Key:    s.repo.IndexKey(name),
+		Size:   index.Size,
+		SHA256: sum,
+		Repository: &RepositoryInfo{
+			Index:       name,
+			TotalChunks: writeStats.TotalChunks,
+			NewChunks:   writeStats.NewChunks,
+			NewBytes:    writeStats.NewBytes,
+		},
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.ExecContext(ctx, "UPDATE backup_records SET manifest = $1 WHERE id = $2", string(data), recordID); err != nil {
+		return nil, fmt.Errorf("failed to record repository index: %w", err)
+	}
+	return manifest, nil
+}
+
+// openRepository 打开仓库中某个备份的数据流
+func (s *Service) openRepository(ctx context.Context, info *RepositoryInfo) (io.ReadCloser, error) {
+	index, err := s.repo.ReadIndex(ctx, info.Index)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.Open(ctx, index), nil
+}
+
+// pruneRepository 删除不再被任何备份引用的块。先取得仓库锁再读取存活索引，
+// 其他进程正在写入时跳过本次回收，留待下次执行。
+func (s *Service) pruneRepository(ctx context.Context) error {
+	s.repoMu.Lock()
+	defer s.repoMu.Unlock()
+	lock, err := s.repo.LockPrune(ctx)
+	if errors.Is(err, repository.ErrLocked) {
+		log.Printf("Repository is in use by another process, skipping prune")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT manifest->'repository'->>'index'
+		FROM backup_records
+		WHERE manifest ? 'repository'
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var live []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		live = append(live, name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	removed, err := s.repo.Prune(ctx, live)
+	if removed > 0 {
+		log.Printf("Repository prune removed %d unreferenced chunks", removed)
+	}
+	return err
+}
+
+// expireRepositoryBackups 按保留天数删除仓库中的旧备份并回收块，跳过固定的备份
+func (s *Service) expireRepositoryBackups() {
+	ctx := context.Background()
+	cutoff := time.Now().AddDate(0, 0, -s.config.Storage.Local.Retention)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, manifest->'repository'->>'index'
+		FROM backup_records
+		WHERE manifest ? 'repository' AND timestamp < $1 AND NOT `+pinActive+`
+	`, cutoff)
+	if err != nil {
+		log.Printf("Failed to query expired repository backups: %v", err)
+		return
+	}
+
+	type expired struct {
+		id    int64
+		index string
+	}
+	var list []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.index); err == nil {
+			list = append(list, e)
+		}
+	}
+	rows.Close()
+
+	for _, e := range list {
+		if err := s.repo.DeleteIndex(ctx, e.index); err != nil {
+			log.Printf("Failed to delete repository index %s: %v", e.index, err)
+			continue
+		}
+		s.db.ExecContext(ctx, "DELETE FROM backup_records WHERE id = $1", e.id)
+	}
+
+	if len(list) > 0 {
+		if err := s.pruneRepository(ctx); err != nil {
+			log.Printf("Repository prune failed: %v", err)
+		}
+	}
+}
+
+// GetRepositoryStats 返回仓库的逻辑大小和实际占用
+func (s *Service) GetRepositoryStats(ctx context.Context) (*RepositoryStats, error) {
+	stats := &RepositoryStats{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM((manifest->>'size')::bigint), 0)
+		FROM backup_records
+		WHERE status = 'completed' AND manifest ? 'repository'
+	`).Scan(&stats.Backups, &stats.LogicalSize)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(stored_size), 0) FROM repository_chunks
+	`).Scan(&stats.Chunks, &stats.RealSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.RealSize > 0 {
+		stats.DedupRatio = float64(stats.LogicalSize) / float64(stats.RealSize)
+	}
+	return stats, nil
+}
+
+// chunkIndex 以数据库表记录仓库中已有的块
+type chunkIndex struct {
+	db *sql.DB
+}
+
+func (c *chunkIndex) Has(ctx context.Context, id string) (bool, error) {
+	var exists bool
+	err := c.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM repository_chunks WHERE id = $1)", id).Scan(&exists)
+	return exists, err
+}
+
+func (c *chunkIndex) Add(ctx context.Context, ref repository.ChunkRef, storedSize int64) error {
+	_, err := c.db.ExecContext(ctx, `
+		INSERT INTO repository_chunks (id, size, stored_size)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO NOTHING
+	`, ref.ID, ref.Size, storedSize)
+	return err
+}
+
+func (c *chunkIndex) Remove(ctx context.Context, id string) error {
+	_, err := c.db.ExecContext(ctx, "DELETE FROM repository_chunks WHERE id = $1", id)
+	return err
+}
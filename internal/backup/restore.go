@@ -0,0 +1,319 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"pg-backup/internal/storage"
+)
+
+// RestoreOptions 恢复参数
+type RestoreOptions struct {
+	// DataDirectory 物理备份恢复的目标数据目录，必须不存在或为空
+	DataDirectory string `json:"dataDirectory"`
+	// TablespaceMapping 表空间 OID 到目标目录的映射，未指定的表空间恢复到 <DataDirectory>_tblspc/<oid>
+	TablespaceMapping map[string]string `json:"tablespaceMapping,omitempty"`
+
+	// Server 逻辑备份恢复的目标服务器（配置中 servers 的名称），为空时为备份来源服务器
+	Server string `json:"server,omitempty"`
+	// Database 逻辑备份恢复的目标数据库，为空时使用配置中的数据库
+	Database string `json:"database,omitempty"`
+	// Objects 只恢复选中的 schema 或表，为空时恢复整个备份
+	Objects *ObjectSelection `json:"objects,omitempty"`
+	// MaskingProfile 恢复完成后执行的脱敏配置，只能用于非生产库
+	MaskingProfile string `json:"maskingProfile,omitempty"`
+}
+
+// Restore 从备份恢复
+func (s *Service) Restore(ctx context.Context, id int64, opts RestoreOptions) error {
+	var kind, status string
+	err := s.db.QueryRowContext(ctx, "SELECT kind, status FROM backup_records WHERE id = $1", id).Scan(&kind, &status)
+	if err != nil {
+		return err
+	}
+	if status != "completed" {
+		return fmt.Errorf("backup %d is not restorable (status: %s)", id, status)
+	}
+
+	manifest, err := s.loadManifest(ctx, id)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return fmt.Errorf("backup %d has no manifest", id)
+	}
+
+	switch kind {
+	case KindPhysical, KindIncremental:
+		if opts.MaskingProfile != "" {
+			return fmt.Errorf("masking is not supported for physical restores, apply it after the cluster is started")
+		}
+		return s.restorePhysical(ctx, manifest, opts)
+	case KindLogical:
+		return s.restoreLogical(ctx, id, manifest, opts)
+	default:
+		return fmt.Errorf("restore of %s backups is not supported", kind)
+	}
+}
+
+// restorePhysical 将物理备份的 tar 文件解压为可启动的数据目录，增量备份会与整条备份链合并
+func (s *Service) restorePhysical(ctx context.Context, manifest *Manifest, opts RestoreOptions) error {
+	if opts.DataDirectory == "" {
+		return fmt.Errorf("dataDirectory is required for physical restore")
+	}
+	if manifest.Physical != nil && manifest.Physical.ParentID != 0 {
+		return s.restoreIncremental(ctx, manifest, opts)
+	}
+	return s.extractPhysical(ctx, manifest, opts)
+}
+
+// extractPhysical 解压单个物理备份
+func (s *Service) extractPhysical(ctx context.Context, manifest *Manifest, opts RestoreOptions) error {
+	dataDir, err := filepath.Abs(opts.DataDirectory)
+	if err != nil {
+		return err
+	}
+	if err := ensureEmptyDir(dataDir); err != nil {
+		return err
+	}
+
+	algorithm := CompressionNone
+	if manifest.Compression != nil {
+		algorithm = manifest.Compression.Algorithm
+	}
+
+	for _, file := range manifest.Files {
+		name := strings.TrimSuffix(file.Name, compressionExt(algorithm))
+		switch {
+		case name == "base.tar":
+			err = s.extractBackupFile(ctx, file, algorithm, dataDir)
+		case name == "pg_wal.tar":
+			err = s.extractBackupFile(ctx, file, algorithm, filepath.Join(dataDir, "pg_wal"))
+		case strings.HasSuffix(name, ".tar"):
+			err = s.restoreTablespace(ctx, file, algorithm, dataDir, strings.TrimSuffix(name, ".tar"), opts.TablespaceMapping)
+		default:
+			// backup_manifest 等非 tar 文件原样放入数据目录
+			err = s.copyBackupFile(ctx, file, algorithm, filepath.Join(dataDir, name))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file.Name, err)
+		}
+	}
+
+	return os.Chmod(dataDir, 0700)
+}
+
+// restoreTablespace 解压表空间并重建 pg_tblspc 下的符号链接
+func (s *Service) restoreTablespace(ctx context.Context, file BackupFile, algorithm, dataDir, oid string, mapping map[string]string) error {
+	target := mapping[oid]
+	if target == "" {
+		target = filepath.Join(dataDir+"_tblspc", oid)
+	}
+	if err := ensureEmptyDir(target); err != nil {
+		return err
+	}
+	if err := s.extractBackupFile(ctx, file, algorithm, target); err != nil {
+		return err
+	}
+
+	link := filepath.Join(dataDir, "pg_tblspc", oid)
+	os.Remove(link)
+	if err := os.MkdirAll(filepath.Dir(link), 0700); err != nil {
+		return err
+	}
+	return os.Symlink(target, link)
+}
+
+// openBackupFile 下载单个备份文件并在读取结束时校验 SHA-256，返回解压后的数据流
+func (s *Service) openBackupFile(ctx context.Context, file BackupFile, algorithm string) (io.ReadCloser, error) {
+	rc, err := s.storage.Download(ctx, file.Key, storage.TransferOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	verified := &verifyingReader{r: rc, hash: sha256.New(), expected: file.SHA256}
+	dec, err := newDecompressor(verified, algorithm)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &stackedReadCloser{Reader: &drainingReader{r: dec, src: verified}, closers: []io.Closer{dec, rc}}, nil
+}
+
+func (s *Service) extractBackupFile(ctx context.Context, file BackupFile, algorithm, dest string) error {
+	rc, err := s.openBackupFile(ctx, file, algorithm)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := extractTar(rc, dest); err != nil {
+		return err
+	}
+	// tar 结束标记之后可能还有填充数据，读完以完成校验
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
+
+func (s *Service) copyBackupFile(ctx context.Context, file BackupFile, algorithm, dest string) error {
+	rc, err := s.openBackupFile(ctx, file, algorithm)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// ensureEmptyDir 确保目录存在且为空，避免覆盖已有数据
+func ensureEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return os.MkdirAll(dir, 0700)
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("directory %s is not empty", dir)
+	}
+	return nil
+}
+
+// extractTar 将 tar 流解压到 dest，拒绝指向 dest 之外的路径
+func extractTar(r io.Reader, dest string) error {
+	if err := os.MkdirAll(dest, 0700); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dest, hdr.Name)
+		if target != dest && !strings.HasPrefix(target, dest+string(os.PathSeparator)) {
+			return fmt.Errorf("illegal path in archive: %s", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)&os.ModePerm|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&os.ModePerm)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source := filepath.Join(dest, hdr.Linkname)
+			if !strings.HasPrefix(source, dest+string(os.PathSeparator)) {
+				return fmt.Errorf("illegal link in archive: %s", hdr.Linkname)
+			}
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// extractTarMember 从 tar 流中取出名为 name 的文件写入 dest，找到后即返回，不读取剩余数据
+func extractTarMember(r io.Reader, name, dest string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%s not found in archive", name)
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || path.Clean(hdr.Name) != name {
+			continue
+		}
+
+		out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+}
+
+// verifyingReader 读取到 EOF 时校验数据的 SHA-256
+type verifyingReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && v.expected != "" {
+		if sum := hex.EncodeToString(v.hash.Sum(nil)); sum != v.expected {
+			return n, fmt.Errorf("checksum mismatch: expected %s, got %s", v.expected, sum)
+		}
+	}
+	return n, err
+}
+
+// drainingReader 解压结束后读完剩余的原始数据，确保校验值覆盖整个文件
+type drainingReader struct {
+	r   io.Reader
+	src io.Reader
+}
+
+func (d *drainingReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err == io.EOF {
+		if _, derr := io.Copy(io.Discard, d.src); derr != nil {
+			return n, derr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// basebackupTar 生成与 pg_basebackup -D - 输出结构相同的 tar：数据目录在前，backup_manifest 在最后
+func basebackupTar(t *testing.T, withManifest bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	files := []struct{ name, body string }{
+		{"PG_VERSION", "17\n"},
+		{"base/1/1259", "heap"},
+		{"pg_wal/000000010000000000000002", "wal"},
+	}
+	if withManifest {
+		files = append(files, struct{ name, body string }{"backup_manifest", `{"PostgreSQL-Backup-Manifest-Version": 2}`})
+	}
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0600, Size: int64(len(f.body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, f.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTarMember(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "backup_manifest")
+	if err := extractTarMember(bytes.NewReader(basebackupTar(t, true)), "backup_manifest", dest); err != nil {
+		t.Fatalf("extractTarMember: %v", err)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"PostgreSQL-Backup-Manifest-Version": 2}` {
+		t.Fatalf("unexpected manifest content %q", data)
+	}
+
+	err = extractTarMember(bytes.NewReader(basebackupTar(t, false)), "backup_manifest", filepath.Join(t.TempDir(), "m"))
+	if err == nil {
+		t.Fatal("expected an error when the archive has no backup_manifest")
+	}
+}
@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// RetentionPolicy 定时任务的保留策略：保留最近 KeepLast 个备份，其余超过 KeepDays 天的删除。
+// 两者都为 0 时不清理，固定的备份总是保留。
+type RetentionPolicy struct {
+	KeepLast int `json:"keepLast,omitempty"`
+	KeepDays int `json:"keepDays,omitempty"`
+}
+
+// Validate 检查保留策略
+func (p *RetentionPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.KeepLast < 0 || p.KeepDays < 0 {
+		return fmt.Errorf("keepLast and keepDays must not be negative")
+	}
+	return nil
+}
+
+// ExpireJobBackups 按保留策略删除定时任务创建的旧备份，返回删除的数量
+func (s *Service) ExpireJobBackups(ctx context.Context, jobID int64, policy *RetentionPolicy) (int, error) {
+	if policy == nil || (policy.KeepLast == 0 && policy.KeepDays == 0) {
+		return 0, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, timestamp FROM backup_records
+		WHERE job_id = $1 AND status = 'completed' AND NOT `+pinActive+`
+		ORDER BY timestamp DESC, id DESC
+	`, jobID)
+	if err != nil {
+		return 0, err
+	}
+	var backups []retentionCandidate
+	for rows.Next() {
+		var c retentionCandidate
+		if err := rows.Scan(&c.id, &c.timestamp); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		backups = append(backups, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, id := range policy.expired(backups, time.Now()) {
+		// 仍有增量备份依赖的父备份会删除失败，留到子备份过期后再删除
+		if err := s.DeleteBackup(id); err != nil {
+			log.Printf("Job %d: failed to delete expired backup %d: %v", jobID, id, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// retentionCandidate 受保留策略管理的备份
+type retentionCandidate struct {
+	id        int64
+	timestamp time.Time
+}
+
+// expired 从按时间倒序排列的备份中选出应删除的备份：前 KeepLast 个和 KeepDays 天内的保留
+func (p *RetentionPolicy) expired(backups []retentionCandidate, now time.Time) []int64 {
+	if p == nil || (p.KeepLast == 0 && p.KeepDays == 0) {
+		return nil
+	}
+	var cutoff time.Time
+	if p.KeepDays > 0 {
+		cutoff = now.AddDate(0, 0, -p.KeepDays)
+	}
+	var ids []int64
+	for i, b := range backups {
+		if i < p.KeepLast || (!cutoff.IsZero() && b.timestamp.After(cutoff)) {
+			continue
+		}
+		ids = append(ids, b.id)
+	}
+	return ids
+}
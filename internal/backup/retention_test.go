@@ -0,0 +1,54 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyExpired(t *testing.T) {
+	now := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+	// 每天一个备份，按时间倒序：id 1 为 0 天前，id 10 为 9 天前
+	var backups []retentionCandidate
+	for i := 0; i < 10; i++ {
+		backups = append(backups, retentionCandidate{id: int64(i + 1), timestamp: now.AddDate(0, 0, -i)})
+	}
+
+	tests := []struct {
+		name   string
+		policy *RetentionPolicy
+		want   []int64
+	}{
+		{"nil policy", nil, nil},
+		{"empty policy", &RetentionPolicy{}, nil},
+		{"keep last", &RetentionPolicy{KeepLast: 3}, []int64{4, 5, 6, 7, 8, 9, 10}},
+		{"keep days", &RetentionPolicy{KeepDays: 5}, []int64{6, 7, 8, 9, 10}},
+		{"keep last beyond keep days", &RetentionPolicy{KeepLast: 7, KeepDays: 2}, []int64{8, 9, 10}},
+		{"keep days beyond keep last", &RetentionPolicy{KeepLast: 1, KeepDays: 4}, []int64{5, 6, 7, 8, 9, 10}},
+		{"keep more than exist", &RetentionPolicy{KeepLast: 20}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.expired(backups, now); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy  *RetentionPolicy
+		wantErr bool
+	}{
+		{nil, false},
+		{&RetentionPolicy{KeepLast: 5, KeepDays: 30}, false},
+		{&RetentionPolicy{KeepLast: -1}, true},
+		{&RetentionPolicy{KeepDays: -1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+		}
+	}
+}
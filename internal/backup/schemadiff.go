@@ -0,0 +1,357 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SchemaSnapshot 从备份中提取的规范化 schema，键为带 schema 的对象名，值为去掉属主后的 DDL
+type SchemaSnapshot struct {
+	Tables      map[string]string            `json:"tables"`
+	Columns     map[string]map[string]string `json:"columns"` // 表 -> 列名 -> 列定义
+	Indexes     map[string]string            `json:"indexes"`
+	Constraints map[string]string            `json:"constraints"`
+	Functions   map[string]string            `json:"functions"`
+	Grants      map[string]string            `json:"grants"`
+	Other       map[string]string            `json:"other"` // 视图、序列、类型、触发器等，键前带对象类型
+}
+
+// SchemaDiff 两个备份之间的 schema 差异
+type SchemaDiff struct {
+	From        int64      `json:"from"`
+	To          int64      `json:"to"`
+	Tables      ObjectDiff `json:"tables"`
+	Columns     ObjectDiff `json:"columns"`
+	Indexes     ObjectDiff `json:"indexes"`
+	Constraints ObjectDiff `json:"constraints"`
+	Functions   ObjectDiff `json:"functions"`
+	Grants      ObjectDiff `json:"grants"`
+	Other       ObjectDiff `json:"other"`
+}
+
+// ObjectDiff 一类对象的增加、删除和修改
+type ObjectDiff struct {
+	Added   []string       `json:"added"`
+	Removed []string       `json:"removed"`
+	Altered []ObjectChange `json:"altered"`
+}
+
+// ObjectChange 修改前后的定义
+type ObjectChange struct {
+	Name   string `json:"name"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+var (
+	dumpHeaderPattern = regexp.MustCompile(`^-- (?:Data for )?Name: (.+); Type: (.+); Schema: (.+); Owner: .*$`)
+	createTablePrefix = regexp.MustCompile(`^CREATE (?:UNLOGGED |FOREIGN )?TABLE `)
+	dumpSetPattern    = regexp.MustCompile(`^SET \w+ = .*;$`)
+)
+
+func newSchemaSnapshot() *SchemaSnapshot {
+	return &SchemaSnapshot{
+		Tables:      make(map[string]string),
+		Columns:     make(map[string]map[string]string),
+		Indexes:     make(map[string]string),
+		Constraints: make(map[string]string),
+		Functions:   make(map[string]string),
+		Grants:      make(map[string]string),
+		Other:       make(map[string]string),
+	}
+}
+
+// parseSchemaSQL 按 pg_dump 输出中每个对象前的 "-- Name: ...; Type: ..." 注释切分 DDL，跳过数据
+func parseSchemaSQL(r io.Reader) (*SchemaSnapshot, error) {
+	snapshot := newSchemaSnapshot()
+	var name, typ, schema string
+	var stmt []string
+	inCopy := false
+
+	flush := func() {
+		if typ != "" && typ != "TABLE DATA" && typ != "SEQUENCE SET" && len(stmt) > 0 {
+			snapshot.add(typ, schema, name, strings.Join(stmt, "\n"))
+		}
+		stmt = nil
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			text := strings.TrimRight(line, "\r\n")
+			switch {
+			case inCopy:
+				inCopy = text != `\.`
+			case strings.HasPrefix(text, "COPY ") && strings.HasSuffix(text, "FROM stdin;"):
+				inCopy = true
+			case strings.HasPrefix(text, "--"):
+				if m := dumpHeaderPattern.FindStringSubmatch(text); m != nil {
+					flush()
+					name, typ, schema = m[1], m[2], m[3]
+				}
+			case strings.TrimSpace(text) == "", strings.Contains(text, " OWNER TO "):
+			// 对象之间的会话设置（如 default_tablespace）和 psql 元命令（如 \restrict）不属于任何对象
+			case dumpSetPattern.MatchString(text), strings.HasPrefix(text, `\`):
+			default:
+				if typ != "" {
+					stmt = append(stmt, text)
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	flush()
+	return snapshot, nil
+}
+
+// add 将一个对象归入对应类别
+func (sn *SchemaSnapshot) add(typ, schema, name, ddl string) {
+	qualified := name
+	if schema != "-" {
+		qualified = schema + "." + name
+	}
+
+	switch typ {
+	case "TABLE", "FOREIGN TABLE":
+		sn.Tables[qualified] = ddl
+		sn.Columns[qualified] = parseColumns(ddl)
+	case "INDEX":
+		sn.Indexes[qualified] = ddl
+	case "CONSTRAINT", "FK CONSTRAINT", "CHECK CONSTRAINT":
+		sn.Constraints[qualified] = ddl
+	case "FUNCTION", "PROCEDURE", "AGGREGATE":
+		sn.Functions[qualified] = ddl
+	case "ACL":
+		// ACL 的名称形如 "TABLE accounts"
+		if kind, object, ok := strings.Cut(name, " "); ok && schema != "-" {
+			qualified = kind + " " + schema + "." + object
+		}
+		sn.Grants[qualified] = ddl
+	default:
+		sn.Other[typ+" "+qualified] = ddl
+	}
+}
+
+// empty 快照中没有任何对象
+func (sn *SchemaSnapshot) empty() bool {
+	return len(sn.Tables) == 0 && len(sn.Indexes) == 0 && len(sn.Constraints) == 0 &&
+		len(sn.Functions) == 0 && len(sn.Grants) == 0 && len(sn.Other) == 0
+}
+
+// parseColumns 从 CREATE TABLE 语句中解析列定义，表级约束不计入
+func parseColumns(ddl string) map[string]string {
+	columns := make(map[string]string)
+	lines := strings.Split(ddl, "\n")
+	inBody := false
+	for _, line := range lines {
+		if !inBody {
+			inBody = createTablePrefix.MatchString(line) && strings.HasSuffix(line, "(")
+			continue
+		}
+		text := strings.TrimSpace(line)
+		if strings.HasPrefix(text, ")") {
+			break
+		}
+		text = strings.TrimSuffix(text, ",")
+		if text == "" || strings.HasPrefix(text, "CONSTRAINT ") {
+			continue
+		}
+		col, def := splitIdent(text)
+		columns[col] = def
+	}
+	return columns
+}
+
+// splitIdent 拆分出开头的标识符（可能带双引号）和其余部分
+func splitIdent(text string) (string, string) {
+	if strings.HasPrefix(text, `"`) {
+		for i := 1; i < len(text); i++ {
+			if text[i] != '"' {
+				continue
+			}
+			if i+1 < len(text) && text[i+1] == '"' {
+				i++
+				continue
+			}
+			return text[:i+1], strings.TrimSpace(text[i+1:])
+		}
+	}
+	ident, rest, _ := strings.Cut(text, " ")
+	return ident, strings.TrimSpace(rest)
+}
+
+// extractSchemaSnapshot 从本地 dump 文件中提取 schema，custom 格式通过 pg_restore --schema-only 转为 SQL
+func extractSchemaSnapshot(ctx context.Context, path, algorithm, format, pgRestore string) (*SchemaSnapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rc, err := newDecompressor(bufio.NewReader(file), algorithm)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	if format != FormatCustom {
+		return parseSchemaSQL(rc)
+	}
+
+	cmd := exec.CommandContext(ctx, pgRestore, "--schema-only", "-f", "-")
+	cmd.Stdin = rc
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	snapshot, perr := parseSchemaSQL(out)
+	io.Copy(io.Discard, out)
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("pg_restore --schema-only failed: %v, stderr: %s", err, stderr.String())
+	}
+	return snapshot, perr
+}
+
+// saveSchemaSnapshot 保存备份的 schema 快照
+func (s *Service) saveSchemaSnapshot(ctx context.Context, id int64, snapshot *SchemaSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO schema_snapshots (backup_id, snapshot) VALUES ($1, $2)
+		ON CONFLICT (backup_id) DO UPDATE SET snapshot = EXCLUDED.snapshot
+	`, id, string(data))
+	return err
+}
+
+// schemaSnapshot 读取备份的 schema 快照，旧备份没有快照时下载备份提取并保存
+func (s *Service) schemaSnapshot(ctx context.Context, id int64) (*SchemaSnapshot, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, "SELECT snapshot FROM schema_snapshots WHERE backup_id = $1", id).Scan(&data)
+	if err == nil {
+		snapshot := newSchemaSnapshot()
+		return snapshot, json.Unmarshal(data, snapshot)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var kind string
+	if err := s.db.QueryRowContext(ctx, "SELECT kind FROM backup_records WHERE id = $1", id).Scan(&kind); err != nil {
+		return nil, err
+	}
+	if kind != KindLogical {
+		return nil, fmt.Errorf("backup %d is a %s backup, schema diff requires logical backups", id, kind)
+	}
+	manifest, err := s.loadManifest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil && manifest.DataOnly {
+		return nil, fmt.Errorf("backup %d is a data-only backup without schema", id)
+	}
+
+	archive, err := s.fetchLogicalBackup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(archive)
+
+	format := FormatPlain
+	if manifest != nil && manifest.Format != "" {
+		format = manifest.Format
+	}
+	pgRestore, err := s.selectPgRestore(manifest, nil)
+	if err != nil && format == FormatCustom {
+		return nil, err
+	}
+	snapshot, err := extractSchemaSnapshot(ctx, archive, CompressionNone, format, pgRestore)
+	if err != nil {
+		return nil, err
+	}
+	// 旧备份的清单没有记录是否包含 schema，提取不到任何对象时按仅数据备份处理，不保存空快照
+	if snapshot.empty() {
+		return nil, fmt.Errorf("backup %d contains no schema objects", id)
+	}
+	return snapshot, s.saveSchemaSnapshot(ctx, id, snapshot)
+}
+
+// DiffBackups 比较两个逻辑备份的 schema
+func (s *Service) DiffBackups(ctx context.Context, from, to int64) (*SchemaDiff, error) {
+	a, err := s.schemaSnapshot(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.schemaSnapshot(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &SchemaDiff{
+		From:        from,
+		To:          to,
+		Tables:      diffObjects(a.Tables, b.Tables),
+		Indexes:     diffObjects(a.Indexes, b.Indexes),
+		Constraints: diffObjects(a.Constraints, b.Constraints),
+		Functions:   diffObjects(a.Functions, b.Functions),
+		Grants:      diffObjects(a.Grants, b.Grants),
+		Other:       diffObjects(a.Other, b.Other),
+	}
+	diff.Columns = diffObjects(flattenColumns(a.Columns), flattenColumns(b.Columns))
+	return diff, nil
+}
+
+// flattenColumns 将列展开为 "表.列" 形式，便于统一比较
+func flattenColumns(tables map[string]map[string]string) map[string]string {
+	flat := make(map[string]string)
+	for table, columns := range tables {
+		for col, def := range columns {
+			flat[table+"."+col] = def
+		}
+	}
+	return flat
+}
+
+func diffObjects(before, after map[string]string) ObjectDiff {
+	diff := ObjectDiff{Added: []string{}, Removed: []string{}, Altered: []ObjectChange{}}
+	for name, def := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+		case old != def:
+			diff.Altered = append(diff.Altered, ObjectChange{Name: name, Before: old, After: def})
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Altered, func(i, j int) bool { return diff.Altered[i].Name < diff.Altered[j].Name })
+	return diff
+}
@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sampleSchemaDump 按 pg_dump 17.6 的纯文本输出格式，包含对象之间的 SET 和 \restrict 元命令
+const sampleSchemaDump = `--
+-- PostgreSQL database dump
+--
+
+\restrict 3mPbYq0fXWb8gKdT5hJcN2sLrVaE9uZo1iQy7wRtMnBvCxF4gHk6jD
+
+-- Dumped from database version 17.6
+-- Dumped by pg_dump version 17.6
+
+SET statement_timeout = 0;
+SET lock_timeout = 0;
+SET idle_in_transaction_session_timeout = 0;
+SET transaction_timeout = 0;
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+SELECT pg_catalog.set_config('search_path', '', false);
+SET check_function_bodies = false;
+SET xmloption = content;
+SET client_min_messages = warning;
+SET row_security = off;
+
+--
+-- Name: pgcrypto; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public;
+
+
+--
+-- Name: add(integer, integer); Type: FUNCTION; Schema: public; Owner: app
+--
+
+CREATE FUNCTION public.add(a integer, b integer) RETURNS integer
+    LANGUAGE sql
+    AS $$SELECT a + b$$;
+
+
+ALTER FUNCTION public.add(a integer, b integer) OWNER TO app;
+
+SET default_tablespace = '';
+
+SET default_table_access_method = heap;
+
+--
+-- Name: accounts; Type: TABLE; Schema: public; Owner: app
+--
+
+CREATE TABLE public.accounts (
+    id integer NOT NULL,
+    "Email Address" text,
+    balance numeric(12,2) DEFAULT 0,
+    CONSTRAINT balance_positive CHECK ((balance >= (0)::numeric))
+);
+
+
+ALTER TABLE public.accounts OWNER TO app;
+
+--
+-- Data for Name: accounts; Type: TABLE DATA; Schema: public; Owner: app
+--
+
+COPY public.accounts (id, "Email Address", balance) FROM stdin;
+1	a@example.com	10.00
+2	-- Name: fake; Type: TABLE; Schema: public; Owner: app	0.00
+\.
+
+
+--
+-- Name: accounts accounts_pkey; Type: CONSTRAINT; Schema: public; Owner: app
+--
+
+ALTER TABLE ONLY public.accounts
+    ADD CONSTRAINT accounts_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: accounts_email_idx; Type: INDEX; Schema: public; Owner: app
+--
+
+CREATE INDEX accounts_email_idx ON public.accounts USING btree ("Email Address");
+
+
+--
+-- Name: TABLE accounts; Type: ACL; Schema: public; Owner: app
+--
+
+GRANT SELECT ON TABLE public.accounts TO reporting;
+
+
+--
+-- PostgreSQL database dump complete
+--
+
+\unrestrict 3mPbYq0fXWb8gKdT5hJcN2sLrVaE9uZo1iQy7wRtMnBvCxF4gHk6jD
+
+`
+
+func TestParseSchemaSQL(t *testing.T) {
+	snapshot, err := parseSchemaSQL(strings.NewReader(sampleSchemaDump))
+	if err != nil {
+		t.Fatalf("parseSchemaSQL: %v", err)
+	}
+
+	want := newSchemaSnapshot()
+	want.Tables["public.accounts"] = "CREATE TABLE public.accounts (\n" +
+		"    id integer NOT NULL,\n" +
+		"    \"Email Address\" text,\n" +
+		"    balance numeric(12,2) DEFAULT 0,\n" +
+		"    CONSTRAINT balance_positive CHECK ((balance >= (0)::numeric))\n" +
+		");"
+	want.Columns["public.accounts"] = map[string]string{
+		"id":              `integer NOT NULL`,
+		`"Email Address"`: `text`,
+		"balance":         `numeric(12,2) DEFAULT 0`,
+	}
+	want.Functions["public.add(integer, integer)"] = "CREATE FUNCTION public.add(a integer, b integer) RETURNS integer\n" +
+		"    LANGUAGE sql\n" +
+		"    AS $$SELECT a + b$$;"
+	want.Constraints["public.accounts accounts_pkey"] = "ALTER TABLE ONLY public.accounts\n" +
+		"    ADD CONSTRAINT accounts_pkey PRIMARY KEY (id);"
+	want.Indexes["public.accounts_email_idx"] = `CREATE INDEX accounts_email_idx ON public.accounts USING btree ("Email Address");`
+	want.Grants["TABLE public.accounts"] = "GRANT SELECT ON TABLE public.accounts TO reporting;"
+	want.Other["EXTENSION pgcrypto"] = "CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public;"
+
+	if !reflect.DeepEqual(snapshot, want) {
+		t.Fatalf("parseSchemaSQL() =\n%+v\nwant\n%+v", snapshot, want)
+	}
+}
+
+func TestParseSchemaSQLStableAcrossDumps(t *testing.T) {
+	before, err := parseSchemaSQL(strings.NewReader(sampleSchemaDump))
+	if err != nil {
+		t.Fatalf("parseSchemaSQL: %v", err)
+	}
+
+	// 每次 pg_dump 的 \restrict 密钥都不同，对象顺序也可能变化
+	reordered := strings.ReplaceAll(sampleSchemaDump, "3mPbYq0fXWb8gKdT5hJcN2sLrVaE9uZo1iQy7wRtMnBvCxF4gHk6jD", "Zx81kQ")
+	fn := sampleSchemaDump[strings.Index(sampleSchemaDump, "--\n-- Name: add("):strings.Index(sampleSchemaDump, "SET default_tablespace")]
+	reordered = strings.Replace(reordered, fn, "", 1)
+	reordered = strings.Replace(reordered, "--\n-- PostgreSQL database dump complete", fn+"--\n-- PostgreSQL database dump complete", 1)
+	after, err := parseSchemaSQL(strings.NewReader(reordered))
+	if err != nil {
+		t.Fatalf("parseSchemaSQL: %v", err)
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		t.Fatalf("snapshots differ:\n%+v\n%+v", before, after)
+	}
+}
+
+func TestSplitIdent(t *testing.T) {
+	tests := []struct {
+		text, ident, rest string
+	}{
+		{"id integer NOT NULL", "id", "integer NOT NULL"},
+		{`"Email Address" text`, `"Email Address"`, "text"},
+		{`"say ""hi""" text`, `"say ""hi"""`, "text"},
+		{"flag", "flag", ""},
+	}
+	for _, tt := range tests {
+		ident, rest := splitIdent(tt.text)
+		if ident != tt.ident || rest != tt.rest {
+			t.Errorf("splitIdent(%q) = %q, %q, want %q, %q", tt.text, ident, rest, tt.ident, tt.rest)
+		}
+	}
+}
+
+func TestDiffObjects(t *testing.T) {
+	before := map[string]string{"a": "1", "b": "2", "c": "3"}
+	after := map[string]string{"b": "2", "c": "30", "d": "4", "e": "5"}
+
+	got := diffObjects(before, after)
+	want := ObjectDiff{
+		Added:   []string{"d", "e"},
+		Removed: []string{"a"},
+		Altered: []ObjectChange{{Name: "c", Before: "3", After: "30"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("diffObjects() = %+v, want %+v", got, want)
+	}
+
+	empty := diffObjects(nil, nil)
+	if empty.Added == nil || empty.Removed == nil || empty.Altered == nil {
+		t.Errorf("empty diff should serialize as empty lists, got %+v", empty)
+	}
+}
+
+func TestFlattenColumns(t *testing.T) {
+	got := flattenColumns(map[string]map[string]string{
+		"public.t": {"id": "integer", "name": "text"},
+	})
+	want := map[string]string{"public.t.id": "integer", "public.t.name": "text"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("flattenColumns() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,279 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// pg_dump 扩展过滤参数的最低版本
+const (
+	pgDumpExtensionVersion        = 14 // -e/--extension
+	pgDumpExcludeExtensionVersion = 17 // --exclude-extension
+)
+
+// Selection 逻辑备份的对象范围，各项均为 pg_dump 的匹配模式（支持 * 和 ? 通配符）
+type Selection struct {
+	Schemas           []string `json:"schemas,omitempty"`           // -n
+	ExcludeSchemas    []string `json:"excludeSchemas,omitempty"`    // -N
+	Tables            []string `json:"tables,omitempty"`            // -t
+	ExcludeTables     []string `json:"excludeTables,omitempty"`     // -T
+	ExcludeTableData  []string `json:"excludeTableData,omitempty"`  // --exclude-table-data，只备份表结构
+	Extensions        []string `json:"extensions,omitempty"`        // -e
+	ExcludeExtensions []string `json:"excludeExtensions,omitempty"` // --exclude-extension
+}
+
+// IsEmpty 未指定任何过滤条件时备份整个数据库
+func (sel *Selection) IsEmpty() bool {
+	return sel == nil || len(sel.Schemas)+len(sel.ExcludeSchemas)+len(sel.Tables)+len(sel.ExcludeTables)+
+		len(sel.ExcludeTableData)+len(sel.Extensions)+len(sel.ExcludeExtensions) == 0
+}
+
+// Validate 检查备份参数之间的冲突，在创建备份记录之前调用
+func (o Options) Validate() error {
+	if err := validateHooks(o.Hooks); err != nil {
+		return err
+	}
+	switch o.Destination {
+	case "", "local", "s3":
+	default:
+		return fmt.Errorf("unsupported destination: %s (supported: local, s3)", o.Destination)
+	}
+	if _, err := normalizeLabels(o.Labels); err != nil {
+		return err
+	}
+	switch o.Kind {
+	case "", KindLogical, KindPhysical, KindIncremental:
+	default:
+		return fmt.Errorf("unsupported backup kind: %s (supported: logical, physical, incremental)", o.Kind)
+	}
+	if o.Kind == KindPhysical || o.Kind == KindIncremental {
+		if !o.Selection.IsEmpty() {
+			return fmt.Errorf("object selection is not supported for %s backups", o.Kind)
+		}
+		if o.Format != "" {
+			return fmt.Errorf("format is not supported for %s backups", o.Kind)
+		}
+		if o.Database != "" {
+			return fmt.Errorf("%s backups always cover the whole cluster, database is not supported", o.Kind)
+		}
+		return nil
+	}
+
+	switch o.Format {
+	case "", FormatPlain, FormatCustom:
+	default:
+		return fmt.Errorf("unsupported dump format: %s (supported: plain, custom)", o.Format)
+	}
+
+	if !o.IncludeData && !o.IncludeSchema {
+		return fmt.Errorf("at least one of includeData and includeSchema must be true")
+	}
+	if o.Selection.IsEmpty() {
+		return nil
+	}
+	return o.Selection.validate(o.IncludeData)
+}
+
+func (sel *Selection) validate(includeData bool) error {
+	lists := map[string][]string{
+		"schemas":           sel.Schemas,
+		"excludeSchemas":    sel.ExcludeSchemas,
+		"tables":            sel.Tables,
+		"excludeTables":     sel.ExcludeTables,
+		"excludeTableData":  sel.ExcludeTableData,
+		"extensions":        sel.Extensions,
+		"excludeExtensions": sel.ExcludeExtensions,
+	}
+	for name, patterns := range lists {
+		for _, p := range patterns {
+			if strings.TrimSpace(p) == "" {
+				return fmt.Errorf("%s contains an empty pattern", name)
+			}
+		}
+	}
+
+	// 指定 -t 时 pg_dump 忽略 -n/-N，schema 过滤会被悄悄丢弃
+	if len(sel.Tables) > 0 && len(sel.Schemas)+len(sel.ExcludeSchemas) > 0 {
+		return fmt.Errorf("tables cannot be combined with schemas or excludeSchemas, qualify the table patterns with a schema instead")
+	}
+	if p := overlap(sel.Schemas, sel.ExcludeSchemas); p != "" {
+		return fmt.Errorf("schema %q is both included and excluded", p)
+	}
+	if p := overlap(sel.Tables, sel.ExcludeTables); p != "" {
+		return fmt.Errorf("table %q is both included and excluded", p)
+	}
+	if p := overlap(sel.Extensions, sel.ExcludeExtensions); p != "" {
+		return fmt.Errorf("extension %q is both included and excluded", p)
+	}
+	if p := overlap(sel.ExcludeTableData, sel.ExcludeTables); p != "" {
+		return fmt.Errorf("table %q is excluded, excluding its data is redundant", p)
+	}
+	if len(sel.ExcludeTableData) > 0 && !includeData {
+		return fmt.Errorf("excludeTableData has no effect on a schema-only backup")
+	}
+	// 包含列表已隐含排除其他扩展，两者不允许同时使用
+	if len(sel.Extensions) > 0 && len(sel.ExcludeExtensions) > 0 {
+		return fmt.Errorf("extensions and excludeExtensions cannot be combined")
+	}
+	return nil
+}
+
+// checkClientSupport 检查 pg_dump 版本是否支持所用的过滤参数
+func (sel *Selection) checkClientSupport(version int) error {
+	if sel.IsEmpty() || len(sel.Extensions)+len(sel.ExcludeExtensions) == 0 {
+		return nil
+	}
+	if len(sel.Extensions) > 0 && version < pgDumpExtensionVersion {
+		return fmt.Errorf("extension filtering requires pg_dump %d or later, found %d", pgDumpExtensionVersion, version)
+	}
+	if len(sel.ExcludeExtensions) > 0 && version < pgDumpExcludeExtensionVersion {
+		return fmt.Errorf("excluding extensions requires pg_dump %d or later, found %d", pgDumpExcludeExtensionVersion, version)
+	}
+	return nil
+}
+
+// args 转换为 pg_dump 参数
+func (sel *Selection) args() []string {
+	if sel.IsEmpty() {
+		return nil
+	}
+
+	var args []string
+	add := func(flag string, patterns []string) {
+		for _, p := range patterns {
+			args = append(args, flag+"="+p)
+		}
+	}
+	add("--schema", sel.Schemas)
+	add("--exclude-schema", sel.ExcludeSchemas)
+	add("--table", sel.Tables)
+	add("--exclude-table", sel.ExcludeTables)
+	add("--exclude-table-data", sel.ExcludeTableData)
+	add("--extension", sel.Extensions)
+	add("--exclude-extension", sel.ExcludeExtensions)
+	return args
+}
+
+// tableFilter 生成 SQL 条件，选出 pg_dump 会读取数据的表（c 为 pg_class，n 为 pg_namespace），
+// 参数从 $1 开始编号。未指定范围时返回空条件。
+func (sel *Selection) tableFilter() (string, []interface{}) {
+	if sel.IsEmpty() {
+		return "", nil
+	}
+
+	var conds []string
+	var args []interface{}
+	param := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	schemas := func(patterns []string) string {
+		var res []string
+		for _, p := range patterns {
+			_, name := namePattern(p)
+			res = append(res, name)
+		}
+		return "n.nspname ~ ANY(" + param(pq.Array(res)) + "::text[])"
+	}
+	tables := func(patterns []string) string {
+		var res []string
+		for _, p := range patterns {
+			schema, name := namePattern(p)
+			if schema == "" {
+				res = append(res, "(c.relname ~ "+param(name)+" AND pg_catalog.pg_table_is_visible(c.oid))")
+			} else {
+				res = append(res, "(c.relname ~ "+param(name)+" AND n.nspname ~ "+param(schema)+")")
+			}
+		}
+		return "(" + strings.Join(res, " OR ") + ")"
+	}
+
+	if len(sel.Schemas) > 0 {
+		conds = append(conds, schemas(sel.Schemas))
+	}
+	if len(sel.ExcludeSchemas) > 0 {
+		conds = append(conds, "NOT "+schemas(sel.ExcludeSchemas))
+	}
+	if len(sel.Tables) > 0 {
+		conds = append(conds, tables(sel.Tables))
+	}
+	if len(sel.ExcludeTables) > 0 {
+		conds = append(conds, "NOT "+tables(sel.ExcludeTables))
+	}
+	// 排除数据的表只导出结构，不需要读取权限
+	if len(sel.ExcludeTableData) > 0 {
+		conds = append(conds, "NOT "+tables(sel.ExcludeTableData))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// namePattern 按 pg_dump 的规则将对象名模式转换为正则：引号外的字母转小写，* 和 ? 为通配符，
+// 点分隔 schema 与名称，$ 按字面匹配，引号内的字符均按字面匹配。未写 schema 时 schema 为空。
+func namePattern(pattern string) (schema, name string) {
+	var parts []string
+	var cur strings.Builder
+	inQuotes := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '"':
+			if inQuotes && i+1 < len(pattern) && pattern[i+1] == '"' {
+				cur.WriteString(`"`)
+				i++
+			} else {
+				inQuotes = !inQuotes
+			}
+		case inQuotes || c == '$':
+			cur.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case c == '.':
+			parts = append(parts, cur.String())
+			cur.Reset()
+		case c == '*':
+			cur.WriteString(".*")
+		case c == '?':
+			cur.WriteString(".")
+		case c >= 'A' && c <= 'Z':
+			cur.WriteByte(c + 'a' - 'A')
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	parts = append(parts, cur.String())
+
+	name = "^(" + parts[len(parts)-1] + ")$"
+	if len(parts) > 1 {
+		schema = "^(" + parts[len(parts)-2] + ")$"
+	}
+	return schema, name
+}
+
+// saveSelection 将对象范围记录到备份记录
+func (s *Service) saveSelection(id int64, sel *Selection) error {
+	if sel.IsEmpty() {
+		return nil
+	}
+	data, err := json.Marshal(sel)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("UPDATE backup_records SET selection = $1 WHERE id = $2", string(data), id)
+	return err
+}
+
+// overlap 返回两个列表中第一个相同的模式
+func overlap(a, b []string) string {
+	seen := make(map[string]bool, len(a))
+	for _, p := range a {
+		seen[p] = true
+	}
+	for _, p := range b {
+		if seen[p] {
+			return p
+		}
+	}
+	return ""
+}
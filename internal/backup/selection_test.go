@@ -0,0 +1,162 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestSelectionValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		sel         Selection
+		includeData bool
+		wantErr     bool
+	}{
+		{"schema-qualified tables", Selection{Tables: []string{"app.users"}, ExcludeTables: []string{"app.log_*"}}, true, false},
+		{"schemas and tables", Selection{Schemas: []string{"app"}, Tables: []string{"app.users"}}, true, true},
+		{"excluded schemas and tables", Selection{ExcludeSchemas: []string{"tmp"}, Tables: []string{"users"}}, true, true},
+		{"exclude table data", Selection{ExcludeTableData: []string{"public.audit_*"}}, true, false},
+		{"empty pattern", Selection{Tables: []string{" "}}, true, true},
+		{"schema included and excluded", Selection{Schemas: []string{"app"}, ExcludeSchemas: []string{"app"}}, true, true},
+		{"table included and excluded", Selection{Tables: []string{"t"}, ExcludeTables: []string{"t"}}, true, true},
+		{"extension included and excluded", Selection{Extensions: []string{"postgis"}, ExcludeExtensions: []string{"postgis"}}, true, true},
+		{"data of excluded table", Selection{ExcludeTables: []string{"t"}, ExcludeTableData: []string{"t"}}, true, true},
+		{"exclude data of schema-only backup", Selection{ExcludeTableData: []string{"t"}}, false, true},
+		{"extensions combined with exclusions", Selection{Extensions: []string{"a"}, ExcludeExtensions: []string{"b"}}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.sel.validate(tt.includeData)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSelectionArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		sel  *Selection
+		want []string
+	}{
+		{"nil", nil, nil},
+		{"empty", &Selection{}, nil},
+		{
+			name: "all flags",
+			sel: &Selection{
+				Schemas:           []string{"app"},
+				ExcludeSchemas:    []string{"tmp"},
+				Tables:            []string{"app.users"},
+				ExcludeTables:     []string{"app.log_*"},
+				ExcludeTableData:  []string{"app.audit"},
+				ExcludeExtensions: []string{"pg_stat_statements"},
+			},
+			want: []string{
+				"--schema=app",
+				"--exclude-schema=tmp",
+				"--table=app.users",
+				"--exclude-table=app.log_*",
+				"--exclude-table-data=app.audit",
+				"--exclude-extension=pg_stat_statements",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sel.args(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("args() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamePattern(t *testing.T) {
+	tests := []struct {
+		pattern      string
+		schema, name string
+	}{
+		{"users", "", "^(users)$"},
+		{"App.Users", "^(app)$", "^(users)$"},
+		{"app.log_*", "^(app)$", "^(log_.*)$"},
+		{"t?", "", "^(t.)$"},
+		{`"My Schema"."Order.Items"`, `^(My Schema)$`, `^(Order\.Items)$`},
+		{`"say ""hi"""`, "", `^(say "hi")$`},
+		{"price$", "", `^(price\$)$`},
+		{"db.app.users", "^(app)$", "^(users)$"},
+	}
+	for _, tt := range tests {
+		schema, name := namePattern(tt.pattern)
+		if schema != tt.schema || name != tt.name {
+			t.Errorf("namePattern(%q) = %q, %q, want %q, %q", tt.pattern, schema, name, tt.schema, tt.name)
+		}
+	}
+}
+
+func TestSelectionTableFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		sel   *Selection
+		where string
+		args  []interface{}
+	}{
+		{"nil", nil, "", nil},
+		{
+			name:  "one schema",
+			sel:   &Selection{Schemas: []string{"app"}},
+			where: "n.nspname ~ ANY($1::text[])",
+			args:  []interface{}{pq.Array([]string{"^(app)$"})},
+		},
+		{
+			name:  "tables with and without schema",
+			sel:   &Selection{Tables: []string{"app.users", "events"}},
+			where: "((c.relname ~ $1 AND n.nspname ~ $2) OR (c.relname ~ $3 AND pg_catalog.pg_table_is_visible(c.oid)))",
+			args:  []interface{}{"^(users)$", "^(app)$", "^(events)$"},
+		},
+		{
+			name: "exclusions",
+			sel: &Selection{
+				ExcludeSchemas:   []string{"tmp"},
+				ExcludeTables:    []string{"public.log_*"},
+				ExcludeTableData: []string{"app.audit"},
+				Extensions:       []string{"postgis"},
+			},
+			where: "NOT n.nspname ~ ANY($1::text[]) AND NOT ((c.relname ~ $2 AND n.nspname ~ $3))" +
+				" AND NOT ((c.relname ~ $4 AND n.nspname ~ $5))",
+			args: []interface{}{pq.Array([]string{"^(tmp)$"}), "^(log_.*)$", "^(public)$", "^(audit)$", "^(app)$"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := tt.sel.tableFilter()
+			if where != tt.where {
+				t.Errorf("where = %q, want %q", where, tt.where)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestOptionsValidateKind(t *testing.T) {
+	tests := []struct {
+		kind    string
+		wantErr bool
+	}{
+		{"", false},
+		{KindLogical, false},
+		{KindPhysical, false},
+		{KindIncremental, false},
+		{"incrmental", true},
+		{"Physical", true},
+	}
+	for _, tt := range tests {
+		opts := Options{Kind: tt.kind, IncludeData: true, IncludeSchema: true}
+		if err := opts.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate() with kind %q error = %v, wantErr %v", tt.kind, err, tt.wantErr)
+		}
+	}
+}
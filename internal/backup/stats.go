@@ -0,0 +1,241 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"pg-backup/internal/config"
+)
+
+const defaultStatsTop = 20
+
+// TableStat 备份时单张表的行数估计和大小，Prev* 为同一目标上一次备份时的值
+type TableStat struct {
+	Schema      string `json:"schema"`
+	Name        string `json:"name"`
+	RowEstimate int64  `json:"rowEstimate"` // pg_class.reltuples
+	LiveTuples  int64  `json:"liveTuples"`
+	DeadTuples  int64  `json:"deadTuples"`
+	TotalBytes  int64  `json:"totalBytes"` // 含索引和 TOAST
+	TableBytes  int64  `json:"tableBytes"`
+	IndexBytes  int64  `json:"indexBytes"`
+
+	PrevRowEstimate *int64 `json:"prevRowEstimate,omitempty"`
+	PrevTotalBytes  *int64 `json:"prevTotalBytes,omitempty"`
+}
+
+// BackupStats 备份时采集的数据库统计，Tables 按总大小降序
+type BackupStats struct {
+	BackupID      int64       `json:"backupId"`
+	PrevBackupID  int64       `json:"prevBackupId,omitempty"` // 用于比较的上一次备份
+	DatabaseBytes int64       `json:"databaseBytes"`
+	TableCount    int         `json:"tableCount"`
+	TotalRows     int64       `json:"totalRows"`
+	CapturedAt    time.Time   `json:"capturedAt"`
+	Tables        []TableStat `json:"tables"`
+}
+
+// TableTrendPoint 单张表在某次备份时的大小
+type TableTrendPoint struct {
+	BackupID    int64     `json:"backupId"`
+	Timestamp   time.Time `json:"timestamp"`
+	RowEstimate int64     `json:"rowEstimate"`
+	TotalBytes  int64     `json:"totalBytes"`
+}
+
+// captureTableStats 从源库的 pg_class/pg_stat_user_tables 采集所有用户表的统计并保存，
+// 失败只记录日志，不影响备份
+func (s *Service) captureTableStats(id int64, conn config.DatabaseConfig) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := s.saveTableStats(ctx, id, conn); err != nil {
+		log.Printf("Failed to capture table statistics for backup %d: %v", id, err)
+	}
+}
+
+func (s *Service) saveTableStats(ctx context.Context, id int64, cfg config.DatabaseConfig) error {
+	source, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	var dbBytes int64
+	if err := source.QueryRowContext(ctx, "SELECT pg_database_size(current_database())").Scan(&dbBytes); err != nil {
+		return err
+	}
+
+	rows, err := source.QueryContext(ctx, `
+		SELECT n.nspname, c.relname, GREATEST(c.reltuples, 0)::bigint,
+		       COALESCE(st.n_live_tup, 0), COALESCE(st.n_dead_tup, 0),
+		       pg_total_relation_size(c.oid), pg_relation_size(c.oid), pg_indexes_size(c.oid)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_stat_user_tables st ON st.relid = c.oid
+		WHERE c.relkind IN ('r', 'm')
+		  AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		  AND n.nspname NOT LIKE 'pg_toast%'
+	`)
+	if err != nil {
+		return err
+	}
+	var stats []TableStat
+	for rows.Next() {
+		var t TableStat
+		if err := rows.Scan(&t.Schema, &t.Name, &t.RowEstimate, &t.LiveTuples, &t.DeadTuples,
+			&t.TotalBytes, &t.TableBytes, &t.IndexBytes); err != nil {
+			rows.Close()
+			return err
+		}
+		stats = append(stats, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE backup_records SET database_bytes = $1 WHERE id = $2", dbBytes, id); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO backup_table_stats (backup_id, schema_name, table_name, row_estimate, live_tuples, dead_tuples,
+		                                total_bytes, table_bytes, index_bytes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, t := range stats {
+		if _, err := stmt.ExecContext(ctx, id, t.Schema, t.Name, t.RowEstimate, t.LiveTuples, t.DeadTuples,
+			t.TotalBytes, t.TableBytes, t.IndexBytes); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetBackupStats 返回备份时最大的 top 张表，并附上同一目标上一次有统计的备份中的值
+func (s *Service) GetBackupStats(ctx context.Context, id int64, top int) (*BackupStats, error) {
+	if top <= 0 {
+		top = defaultStatsTop
+	}
+
+	stats := &BackupStats{BackupID: id}
+	var dbBytes sql.NullInt64
+	var target string
+	var timestamp time.Time
+	err := s.db.QueryRowContext(ctx, `
+		SELECT database_bytes, COALESCE(target, $2), timestamp FROM backup_records WHERE id = $1
+	`, id, s.config.Database.Database).Scan(&dbBytes, &target, &timestamp)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("backup %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !dbBytes.Valid {
+		return nil, fmt.Errorf("no statistics captured for backup %d", id)
+	}
+	stats.DatabaseBytes = dbBytes.Int64
+	stats.CapturedAt = timestamp
+
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(row_estimate), 0) FROM backup_table_stats WHERE backup_id = $1
+	`, id).Scan(&stats.TableCount, &stats.TotalRows); err != nil {
+		return nil, err
+	}
+
+	var prev sql.NullInt64
+	err = s.db.QueryRowContext(ctx, `
+		SELECT id FROM backup_records
+		WHERE COALESCE(target, $1) = $2 AND database_bytes IS NOT NULL AND timestamp < $3
+		ORDER BY timestamp DESC
+		LIMIT 1
+	`, s.config.Database.Database, target, timestamp).Scan(&prev)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	stats.PrevBackupID = prev.Int64
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT t.schema_name, t.table_name, t.row_estimate, t.live_tuples, t.dead_tuples,
+		       t.total_bytes, t.table_bytes, t.index_bytes, p.row_estimate, p.total_bytes
+		FROM backup_table_stats t
+		LEFT JOIN backup_table_stats p
+		  ON p.backup_id = $2 AND p.schema_name = t.schema_name AND p.table_name = t.table_name
+		WHERE t.backup_id = $1
+		ORDER BY t.total_bytes DESC, t.schema_name, t.table_name
+		LIMIT $3
+	`, id, prev, top)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats.Tables = []TableStat{}
+	for rows.Next() {
+		var t TableStat
+		var prevRows, prevBytes sql.NullInt64
+		if err := rows.Scan(&t.Schema, &t.Name, &t.RowEstimate, &t.LiveTuples, &t.DeadTuples,
+			&t.TotalBytes, &t.TableBytes, &t.IndexBytes, &prevRows, &prevBytes); err != nil {
+			return nil, err
+		}
+		if prevRows.Valid {
+			t.PrevRowEstimate, t.PrevTotalBytes = &prevRows.Int64, &prevBytes.Int64
+		}
+		stats.Tables = append(stats.Tables, t)
+	}
+	return stats, rows.Err()
+}
+
+// GetTableTrend 返回目标中一张表（schema.table，未写 schema 时为 public）在最近 limit 次备份中的大小变化，
+// 按时间升序，target 为空时为配置中的数据库
+func (s *Service) GetTableTrend(ctx context.Context, target, table string, limit int) ([]TableTrendPoint, error) {
+	if limit <= 0 {
+		limit = 30
+	}
+	if target == "" {
+		target = s.config.Database.Database
+	}
+	schema, name, ok := strings.Cut(table, ".")
+	if !ok {
+		schema, name = "public", table
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT * FROM (
+			SELECT r.id, r.timestamp, t.row_estimate, t.total_bytes
+			FROM backup_table_stats t
+			JOIN backup_records r ON r.id = t.backup_id
+			WHERE t.schema_name = $1 AND t.table_name = $2 AND COALESCE(r.target, $4) = $5
+			ORDER BY r.timestamp DESC
+			LIMIT $3
+		) recent
+		ORDER BY timestamp
+	`, schema, name, limit, s.config.Database.Database, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []TableTrendPoint{}
+	for rows.Next() {
+		var p TableTrendPoint
+		if err := rows.Scan(&p.BackupID, &p.Timestamp, &p.RowEstimate, &p.TotalBytes); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ArchiveEntry pg_restore --list 输出中的一个对象
+type ArchiveEntry struct {
+	ID     int    `json:"id"`
+	Type   string `json:"type"`
+	Schema string `json:"schema,omitempty"`
+	Name   string `json:"name"`
+	Owner  string `json:"owner,omitempty"`
+
+	line string
+}
+
+// ArchiveContents custom 格式备份的对象树：schema -> 表 -> 相关对象
+type ArchiveContents struct {
+	BackupID int64          `json:"backupId"`
+	Schemas  []SchemaNode   `json:"schemas"`
+	Global   []ArchiveEntry `json:"global,omitempty"` // 不属于任何 schema 的对象，如扩展
+}
+
+// SchemaNode schema 下的表和其他对象
+type SchemaNode struct {
+	Name    string         `json:"name"`
+	Tables  []TableNode    `json:"tables"`
+	Objects []ArchiveEntry `json:"objects,omitempty"`
+}
+
+// TableNode 表及其数据、约束、触发器等相关对象
+type TableNode struct {
+	Name    string         `json:"name"`
+	HasData bool           `json:"hasData"`
+	Related []ArchiveEntry `json:"related,omitempty"`
+}
+
+var tocLinePattern = regexp.MustCompile(`^(\d+); \d+ \d+ (.+)$`)
+
+// tocTypes 含空格的对象类型，按长度降序匹配
+var tocTypes = []string{
+	"PUBLICATION TABLES IN SCHEMA",
+	"TEXT SEARCH CONFIGURATION",
+	"TEXT SEARCH DICTIONARY",
+	"MATERIALIZED VIEW DATA",
+	"FOREIGN DATA WRAPPER",
+	"TEXT SEARCH TEMPLATE",
+	"TEXT SEARCH PARSER",
+	"DATABASE PROPERTIES",
+	"SEQUENCE OWNED BY",
+	"PUBLICATION TABLE",
+	"MATERIALIZED VIEW",
+	"CHECK CONSTRAINT",
+	"OPERATOR FAMILY",
+	"OPERATOR CLASS",
+	"STATISTICS DATA",
+	"EVENT TRIGGER",
+	"FK CONSTRAINT",
+	"FOREIGN TABLE",
+	"ACCESS METHOD",
+	"LARGE OBJECT",
+	"ROW SECURITY",
+	"SEQUENCE SET",
+	"INDEX ATTACH",
+	"USER MAPPING",
+	"DEFAULT ACL",
+	"SHELL TYPE",
+	"TABLE DATA",
+}
+
+// tableChildTypes 标签以表名开头的从属对象
+var tableChildTypes = map[string]bool{
+	"CONSTRAINT":       true,
+	"CHECK CONSTRAINT": true,
+	"FK CONSTRAINT":    true,
+	"DEFAULT":          true,
+	"TRIGGER":          true,
+	"RULE":             true,
+	"POLICY":           true,
+	"ROW SECURITY":     true,
+}
+
+// parseTOC 解析 pg_restore --list 的输出，忽略注释行
+func parseTOC(data []byte) []ArchiveEntry {
+	var entries []ArchiveEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		m := tocLinePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		id, _ := strconv.Atoi(m[1])
+		entry := ArchiveEntry{ID: id, line: line}
+
+		rest := m[2]
+		for _, t := range tocTypes {
+			if strings.HasPrefix(rest, t+" ") {
+				entry.Type = t
+				break
+			}
+		}
+		if entry.Type == "" {
+			entry.Type, _, _ = strings.Cut(rest, " ")
+		}
+		fields := strings.Fields(strings.TrimPrefix(rest, entry.Type))
+
+		switch len(fields) {
+		case 0:
+		case 1:
+			entry.Name = fields[0]
+		case 2:
+			entry.Schema, entry.Name = fields[0], fields[1]
+		default:
+			entry.Schema = fields[0]
+			entry.Name = strings.Join(fields[1:len(fields)-1], " ")
+			entry.Owner = fields[len(fields)-1]
+		}
+		if entry.Schema == "-" {
+			entry.Schema = ""
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// tableOf 返回从属于表的对象所属的表名，不属于任何表时返回空
+func (e ArchiveEntry) tableOf() string {
+	switch {
+	case e.Type == "TABLE" || e.Type == "TABLE DATA":
+		return e.Name
+	case tableChildTypes[e.Type]:
+		table, _, _ := strings.Cut(e.Name, " ")
+		return table
+	case e.Type == "COMMENT" || e.Type == "ACL":
+		if name, ok := strings.CutPrefix(e.Name, "TABLE "); ok {
+			return name
+		}
+		if column, ok := strings.CutPrefix(e.Name, "COLUMN "); ok {
+			table, _, _ := strings.Cut(column, ".")
+			return table
+		}
+	}
+	return ""
+}
+
+// listArchive 运行 pg_restore --list 读取 custom 格式备份的目录
+func listArchive(ctx context.Context, pgRestore, archive string) ([]ArchiveEntry, error) {
+	cmd := exec.CommandContext(ctx, pgRestore, "--list", archive)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("pg_restore --list failed: %v, stderr: %s", err, stderr.String())
+	}
+	return parseTOC(out), nil
+}
+
+// buildContents 将对象列表组织为 schema -> 表 的树
+func buildContents(entries []ArchiveEntry) ArchiveContents {
+	var contents ArchiveContents
+	schemas := make(map[string]*SchemaNode)
+	var order []string
+	tables := make(map[string]map[string]*TableNode)
+
+	schemaNode := func(name string) *SchemaNode {
+		if node, ok := schemas[name]; ok {
+			return node
+		}
+		schemas[name] = &SchemaNode{Name: name, Tables: []TableNode{}}
+		tables[name] = make(map[string]*TableNode)
+		order = append(order, name)
+		return schemas[name]
+	}
+
+	// 先建立所有表，再归类其他对象，TOC 中从属对象可能出现在表之前
+	for _, e := range entries {
+		if e.Type == "SCHEMA" {
+			schemaNode(e.Name)
+		}
+		if e.Type == "TABLE" && e.Schema != "" {
+			schemaNode(e.Schema)
+			tables[e.Schema][e.Name] = &TableNode{Name: e.Name}
+		}
+	}
+
+	for _, e := range entries {
+		switch {
+		case e.Type == "SCHEMA" || e.Type == "TABLE":
+		case e.Schema == "":
+			contents.Global = append(contents.Global, e)
+		default:
+			node := schemaNode(e.Schema)
+			if table := tables[e.Schema][e.tableOf()]; table != nil {
+				if e.Type == "TABLE DATA" {
+					table.HasData = true
+				} else {
+					table.Related = append(table.Related, e)
+				}
+				continue
+			}
+			node.Objects = append(node.Objects, e)
+		}
+	}
+
+	for _, name := range order {
+		node := schemas[name]
+		for _, e := range entries {
+			if e.Type == "TABLE" && e.Schema == name {
+				node.Tables = append(node.Tables, *tables[name][e.Name])
+			}
+		}
+		contents.Schemas = append(contents.Schemas, *node)
+	}
+	return contents
+}
@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+)
+
+const sampleTOC = `;
+; Archive created at 2024-05-01 02:00:00 UTC
+;     dbname: app
+;     TOC Entries: 12
+;
+; Selected TOC Entries:
+;
+5; 2615 16385 SCHEMA - app postgres
+2; 3079 16386 EXTENSION - pgcrypto
+218; 1259 16390 TABLE app users postgres
+219; 1259 16395 SEQUENCE app users_id_seq postgres
+220; 1259 16396 TABLE public events postgres
+3001; 2604 16400 DEFAULT app users id postgres
+3010; 2606 16402 CONSTRAINT app users users_pkey postgres
+3020; 2606 16405 FK CONSTRAINT app orders orders_user_id_fkey postgres
+3030; 0 16390 TABLE DATA app users postgres
+3040; 0 0 COMMENT app TABLE users postgres
+3050; 0 0 ACL app COLUMN users.email postgres
+3060; 2620 16410 TRIGGER app users users_audit postgres
+`
+
+// tocEntries 去掉原始行，便于与期望值比较
+func tocEntries(entries []ArchiveEntry) []ArchiveEntry {
+	out := make([]ArchiveEntry, len(entries))
+	for i, e := range entries {
+		e.line = ""
+		out[i] = e
+	}
+	return out
+}
+
+func entryIDs(entries []ArchiveEntry) []int {
+	ids := []int{}
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestParseTOC(t *testing.T) {
+	entries := parseTOC([]byte(sampleTOC))
+	want := []ArchiveEntry{
+		{ID: 5, Type: "SCHEMA", Name: "app", Owner: "postgres"},
+		{ID: 2, Type: "EXTENSION", Name: "pgcrypto"},
+		{ID: 218, Type: "TABLE", Schema: "app", Name: "users", Owner: "postgres"},
+		{ID: 219, Type: "SEQUENCE", Schema: "app", Name: "users_id_seq", Owner: "postgres"},
+		{ID: 220, Type: "TABLE", Schema: "public", Name: "events", Owner: "postgres"},
+		{ID: 3001, Type: "DEFAULT", Schema: "app", Name: "users id", Owner: "postgres"},
+		{ID: 3010, Type: "CONSTRAINT", Schema: "app", Name: "users users_pkey", Owner: "postgres"},
+		{ID: 3020, Type: "FK CONSTRAINT", Schema: "app", Name: "orders orders_user_id_fkey", Owner: "postgres"},
+		{ID: 3030, Type: "TABLE DATA", Schema: "app", Name: "users", Owner: "postgres"},
+		{ID: 3040, Type: "COMMENT", Schema: "app", Name: "TABLE users", Owner: "postgres"},
+		{ID: 3050, Type: "ACL", Schema: "app", Name: "COLUMN users.email", Owner: "postgres"},
+		{ID: 3060, Type: "TRIGGER", Schema: "app", Name: "users users_audit", Owner: "postgres"},
+	}
+
+	if got := tocEntries(entries); !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseTOC() =\n%+v\nwant\n%+v", got, want)
+	}
+	if entries[2].line != "218; 1259 16390 TABLE app users postgres" {
+		t.Errorf("original line not kept: %q", entries[2].line)
+	}
+}
+
+func TestArchiveEntryTableOf(t *testing.T) {
+	tests := []struct {
+		entry ArchiveEntry
+		want  string
+	}{
+		{ArchiveEntry{Type: "TABLE", Name: "users"}, "users"},
+		{ArchiveEntry{Type: "TABLE DATA", Name: "users"}, "users"},
+		{ArchiveEntry{Type: "CONSTRAINT", Name: "users users_pkey"}, "users"},
+		{ArchiveEntry{Type: "FK CONSTRAINT", Name: "orders orders_user_id_fkey"}, "orders"},
+		{ArchiveEntry{Type: "DEFAULT", Name: "users id"}, "users"},
+		{ArchiveEntry{Type: "COMMENT", Name: "TABLE users"}, "users"},
+		{ArchiveEntry{Type: "ACL", Name: "COLUMN users.email"}, "users"},
+		{ArchiveEntry{Type: "COMMENT", Name: "FUNCTION f()"}, ""},
+		{ArchiveEntry{Type: "SEQUENCE", Name: "users_id_seq"}, ""},
+		{ArchiveEntry{Type: "INDEX", Name: "users_email_idx"}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.entry.tableOf(); got != tt.want {
+			t.Errorf("%s %q: tableOf() = %q, want %q", tt.entry.Type, tt.entry.Name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildContents(t *testing.T) {
+	contents := buildContents(parseTOC([]byte(sampleTOC)))
+
+	if got := entryIDs(contents.Global); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("global objects = %v, want [2]", got)
+	}
+	if len(contents.Schemas) != 2 || contents.Schemas[0].Name != "app" || contents.Schemas[1].Name != "public" {
+		t.Fatalf("unexpected schemas: %+v", contents.Schemas)
+	}
+
+	app := contents.Schemas[0]
+	if len(app.Tables) != 1 || app.Tables[0].Name != "users" || !app.Tables[0].HasData {
+		t.Fatalf("unexpected tables in app: %+v", app.Tables)
+	}
+	if got := entryIDs(app.Tables[0].Related); !reflect.DeepEqual(got, []int{3001, 3010, 3040, 3050, 3060}) {
+		t.Errorf("related objects of app.users = %v", got)
+	}
+	// orders 不在备份中，它的外键归入 schema 的其他对象
+	if got := entryIDs(app.Objects); !reflect.DeepEqual(got, []int{219, 3020}) {
+		t.Errorf("other objects in app = %v", got)
+	}
+
+	public := contents.Schemas[1]
+	if len(public.Tables) != 1 || public.Tables[0].HasData {
+		t.Errorf("unexpected tables in public: %+v", public.Tables)
+	}
+}
@@ -0,0 +1,143 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"pg-backup/internal/storage"
+)
+
+// volumeSize 返回本次备份生效的分卷大小，0 表示不分卷
+func (s *Service) volumeSize(opts Options) int64 {
+	switch {
+	case opts.VolumeSize < 0:
+		return 0
+	case opts.VolumeSize > 0:
+		return opts.VolumeSize
+	default:
+		return s.config.Storage.VolumeSize
+	}
+}
+
+// volumeKey 分卷以三位序号作为后缀，例如 backup_xxx.sql.gz.001
+func volumeKey(key string, index int) string {
+	return fmt.Sprintf("%s.%03d", key, index)
+}
+
+// storeVolumes 将文件按固定大小切分并逐卷上传，同时计算每卷和整体的校验值
+func (s *Service) storeVolumes(ctx context.Context, sourceFile, key string, volumeSize int64, transfer storage.TransferOptions) (*Manifest, error) {
+	file, err := os.Open(sourceFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{Key: key, Size: info.Size()}
+	whole := sha256.New()
+
+	for index, remaining := 1, info.Size(); remaining > 0; index++ {
+		size := volumeSize
+		if remaining < size {
+			size = remaining
+		}
+
+		part := sha256.New()
+		reader := io.TeeReader(io.LimitReader(file, size), io.MultiWriter(whole, part))
+		vol := Volume{Index: index, Key: volumeKey(key, index), Size: size}
+
+		if err := s.storage.Upload(ctx, vol.Key, reader, size, transfer); err != nil {
+			return nil, fmt.Errorf("failed to store volume %d: %w", index, err)
+		}
+
+		vol.SHA256 = hex.EncodeToString(part.Sum(nil))
+		manifest.Volumes = append(manifest.Volumes, vol)
+		remaining -= size
+	}
+
+	manifest.SHA256 = hex.EncodeToString(whole.Sum(nil))
+	return manifest, nil
+}
+
+// volumeReader 依次读取各分卷并在每卷读完时校验大小和 SHA-256
+type volumeReader struct {
+	ctx      context.Context
+	storage  *storage.Service
+	volumes  []Volume
+	transfer storage.TransferOptions
+
+	current io.ReadCloser
+	hash    hash.Hash
+	read    int64
+}
+
+func (s *Service) openVolumes(ctx context.Context, volumes []Volume, transfer storage.TransferOptions) io.ReadCloser {
+	return &volumeReader{
+		ctx:      ctx,
+		storage:  s.storage,
+		volumes:  volumes,
+		transfer: transfer,
+	}
+}
+
+func (r *volumeReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.volumes) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := r.storage.Download(r.ctx, r.volumes[0].Key, r.transfer)
+			if err != nil {
+				return 0, fmt.Errorf("failed to open volume %d: %w", r.volumes[0].Index, err)
+			}
+			r.current, r.hash, r.read = rc, sha256.New(), 0
+		}
+
+		n, err := r.current.Read(p)
+		if n > 0 {
+			r.hash.Write(p[:n])
+			r.read += int64(n)
+		}
+		if err == io.EOF {
+			if verr := r.finishVolume(); verr != nil {
+				return n, verr
+			}
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *volumeReader) finishVolume() error {
+	vol := r.volumes[0]
+	r.current.Close()
+	r.current = nil
+	r.volumes = r.volumes[1:]
+
+	if r.read != vol.Size {
+		return fmt.Errorf("volume %d size mismatch: expected %d bytes, got %d", vol.Index, vol.Size, r.read)
+	}
+	if sum := hex.EncodeToString(r.hash.Sum(nil)); sum != vol.SHA256 {
+		return fmt.Errorf("volume %d checksum mismatch: expected %s, got %s", vol.Index, vol.SHA256, sum)
+	}
+	return nil
+}
+
+func (r *volumeReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
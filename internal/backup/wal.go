@@ -0,0 +1,235 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"pg-backup/internal/config"
+	"pg-backup/internal/storage"
+)
+
+// walPrefix WAL 归档在存储中的根路径，按时间线分目录
+const walPrefix = "wal"
+
+var (
+	walSegmentPattern = regexp.MustCompile(`^([0-9A-F]{8})[0-9A-F]{16}$`)
+	walHistoryPattern = regexp.MustCompile(`^([0-9A-F]{8})\.history$`)
+	walBackupPattern  = regexp.MustCompile(`^([0-9A-F]{8})[0-9A-F]{16}\.[0-9A-F]{8}\.backup$`)
+	// 备库提升时 PostgreSQL 会把旧时间线上未写满的段以 .partial 归档
+	walPartialPattern = regexp.MustCompile(`^([0-9A-F]{8})[0-9A-F]{16}\.partial$`)
+)
+
+// WALSegment 已归档的 WAL 文件
+type WALSegment struct {
+	Name        string    `json:"name"`
+	Timeline    int       `json:"timeline"`
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	Compression string    `json:"compression"`
+	ArchivedAt  time.Time `json:"archivedAt"`
+}
+
+// WALTimeline 某条时间线上的归档概况
+type WALTimeline struct {
+	Timeline     int       `json:"timeline"`
+	Segments     int       `json:"segments"`
+	FirstSegment string    `json:"firstSegment"`
+	LastSegment  string    `json:"lastSegment"`
+	TotalSize    int64     `json:"totalSize"`
+	LastArchived time.Time `json:"lastArchived"`
+}
+
+// walTimeline 从 WAL 文件名解析时间线，无法识别的文件返回错误
+func walTimeline(name string) (int, error) {
+	for _, p := range []*regexp.Regexp{walSegmentPattern, walHistoryPattern, walBackupPattern, walPartialPattern} {
+		if m := p.FindStringSubmatch(name); m != nil {
+			var tli int
+			_, err := fmt.Sscanf(m[1], "%X", &tli)
+			return tli, err
+		}
+	}
+	return 0, fmt.Errorf("not a WAL file name: %s", name)
+}
+
+// walCompression WAL 使用独立的压缩设置，未配置时使用 zstd 流式压缩
+func (s *Service) walCompression() config.CompressionConfig {
+	c := s.config.WAL.Compression
+	if c.Algorithm == "" {
+		c.Algorithm = CompressionZstd
+	}
+	if c.Level == 0 && c.Algorithm == CompressionZstd {
+		c.Level = 3
+	}
+	if c.Level == 0 && c.Algorithm == CompressionGzip {
+		c.Level = 6
+	}
+	c.Method = CompressByStream
+	return c
+}
+
+// ArchiveWAL 将一个 WAL 文件压缩后归档到存储，供 archive_command 调用。
+// 同名文件已归档且内容一致时直接返回成功，内容不一致时报错，符合 archive_command 的语义。
+func (s *Service) ArchiveWAL(ctx context.Context, filePath, name string) error {
+	timeline, err := walTimeline(name)
+	if err != nil {
+		return err
+	}
+
+	rawSum, err := fileChecksum(filePath)
+	if err != nil {
+		return err
+	}
+
+	var existing string
+	err = s.db.QueryRowContext(ctx, "SELECT raw_sha256 FROM wal_segments WHERE name = $1", name).Scan(&existing)
+	switch {
+	case err == nil && existing == rawSum:
+		return nil
+	case err == nil:
+		return fmt.Errorf("WAL file %s already archived with different content", name)
+	case err != sql.ErrNoRows:
+		return err
+	}
+
+	compression := s.walCompression()
+	key := path.Join(s.objectKey(walPrefix), fmt.Sprintf("%08X", timeline), name)
+	file, err := s.uploadBackupFile(ctx, filePath, key, compression, storage.TransferOptions{})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO wal_segments (name, timeline, key, size, sha256, raw_sha256, compression)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (name) DO NOTHING
+	`, name, timeline, file.Key, file.Size, file.SHA256, rawSum, compression.Algorithm)
+	return err
+}
+
+// FetchWAL 从归档中取回一个 WAL 文件并解压到 dest，供 restore_command 调用
+func (s *Service) FetchWAL(ctx context.Context, name, dest string) error {
+	var file BackupFile
+	var algorithm string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT key, size, sha256, compression FROM wal_segments WHERE name = $1
+	`, name).Scan(&file.Key, &file.Size, &file.SHA256, &algorithm)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("WAL file %s not found in archive", name)
+	}
+	if err != nil {
+		return err
+	}
+	file.Name = name
+
+	// 先写临时文件再改名，避免 PostgreSQL 读到不完整的段
+	tmp := dest + ".pg-backup.tmp"
+	if err := s.copyBackupFile(ctx, file, algorithm, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// GetWALTimelines 按时间线汇总已归档的 WAL
+func (s *Service) GetWALTimelines(ctx context.Context) ([]WALTimeline, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT timeline, COUNT(*), MIN(name), MAX(name), COALESCE(SUM(size), 0), MAX(archived_at)
+		FROM wal_segments
+		WHERE name !~ '\.'
+		GROUP BY timeline
+		ORDER BY timeline
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var timelines []WALTimeline
+	for rows.Next() {
+		var t WALTimeline
+		if err := rows.Scan(&t.Timeline, &t.Segments, &t.FirstSegment, &t.LastSegment, &t.TotalSize, &t.LastArchived); err != nil {
+			return nil, err
+		}
+		timelines = append(timelines, t)
+	}
+	return timelines, rows.Err()
+}
+
+// GetWALSegments 返回某条时间线上已归档的 WAL 文件
+func (s *Service) GetWALSegments(ctx context.Context, timeline int) ([]WALSegment, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT name, timeline, key, size, sha256, compression, archived_at
+		FROM wal_segments
+		WHERE timeline = $1
+		ORDER BY name
+	`, timeline)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var segments []WALSegment
+	for rows.Next() {
+		var seg WALSegment
+		if err := rows.Scan(&seg.Name, &seg.Timeline, &seg.Key, &seg.Size, &seg.SHA256, &seg.Compression, &seg.ArchivedAt); err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+	}
+	return segments, rows.Err()
+}
+
+// spooledWAL 从接收目录的文件名中挑出可以归档的 WAL 文件。
+// pg_receivewal 只在名字最大的 .partial 段上继续写入，更早的 .partial 是时间线切换后留下的，可以归档。
+func spooledWAL(names []string) []string {
+	current := ""
+	for _, name := range names {
+		if walPartialPattern.MatchString(name) && name > current {
+			current = name
+		}
+	}
+
+	var ready []string
+	for _, name := range names {
+		if name == current {
+			continue
+		}
+		if walSegmentPattern.MatchString(name) || walHistoryPattern.MatchString(name) || walPartialPattern.MatchString(name) {
+			ready = append(ready, name)
+		}
+	}
+	return ready
+}
+
+// shipSpooledWAL 归档 pg_receivewal 写入目录中已完成的 WAL 文件，成功后删除本地副本
+func (s *Service) shipSpooledWAL(ctx context.Context, spoolDir string) {
+	entries, err := os.ReadDir(spoolDir)
+	if err != nil {
+		log.Printf("Failed to read WAL spool directory %s: %v", spoolDir, err)
+		return
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	for _, name := range spooledWAL(names) {
+		filePath := filepath.Join(spoolDir, name)
+		if err := s.ArchiveWAL(ctx, filePath, name); err != nil {
+			log.Printf("Failed to archive WAL %s: %v", name, err)
+			continue
+		}
+		os.Remove(filePath)
+	}
+}
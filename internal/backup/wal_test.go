@@ -0,0 +1,76 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWALTimeline(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    int
+		wantErr bool
+	}{
+		{"000000010000000000000005", 1, false},
+		{"0000000A00000001000000FF", 10, false},
+		{"00000002.history", 2, false},
+		{"000000010000000000000005.00000028.backup", 1, false},
+		// 备库提升后归档的旧时间线上的部分段
+		{"000000010000000000000005.partial", 1, false},
+		{"000000010000000000000005.partial.tmp", 0, true},
+		{"00000001000000000000005", 0, true},
+		{"RECOVERYHISTORY", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := walTimeline(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("walTimeline(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("walTimeline(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSpooledWAL(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "receiving",
+			files: []string{"000000010000000000000004", "000000010000000000000005.partial"},
+			want:  []string{"000000010000000000000004"},
+		},
+		{
+			// 提升后 pg_receivewal 在新时间线上继续接收，旧时间线的 .partial 不会再被写入
+			name: "after promotion",
+			files: []string{
+				"000000010000000000000005.partial",
+				"00000002.history",
+				"000000020000000000000005",
+				"000000020000000000000006.partial",
+			},
+			want: []string{"000000010000000000000005.partial", "00000002.history", "000000020000000000000005"},
+		},
+		{
+			name:  "ignores unknown files",
+			files: []string{"000000010000000000000004", "000000010000000000000005.pg-backup.tmp", "notes.txt"},
+			want:  []string{"000000010000000000000004"},
+		},
+		{
+			name:  "empty",
+			files: nil,
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := spooledWAL(tt.files); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("spooledWAL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
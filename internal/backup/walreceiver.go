@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	walShipInterval     = 10 * time.Second
+	walReceiverMinDelay = 5 * time.Second
+	walReceiverMaxDelay = 5 * time.Minute
+	defaultWALSlot      = "pg_backup"
+)
+
+// RunWALReceiver 监管 pg_receivewal 进程：异常退出后按指数退避重启，
+// 同时定期将接收目录中已完成的 WAL 段归档到存储，直到 ctx 结束
+func (s *Service) RunWALReceiver(ctx context.Context) {
+	cfg := s.config.WAL
+	if cfg.SpoolDir == "" {
+		log.Printf("WAL receiver disabled: wal.spoolDir is not configured")
+		return
+	}
+	if err := os.MkdirAll(cfg.SpoolDir, 0700); err != nil {
+		log.Printf("WAL receiver disabled: %v", err)
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(walShipInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.shipSpooledWAL(ctx, cfg.SpoolDir)
+			}
+		}
+	}()
+
+	if err := s.runReceivewal(ctx, "--create-slot", "--if-not-exists"); err != nil {
+		log.Printf("Failed to create replication slot: %v", err)
+	}
+
+	delay := walReceiverMinDelay
+	for ctx.Err() == nil {
+		started := time.Now()
+		err := s.runReceivewal(ctx, "-D", cfg.SpoolDir)
+		if ctx.Err() != nil {
+			break
+		}
+		log.Printf("pg_receivewal exited: %v", err)
+
+		// 运行足够久后视为恢复正常，重置退避时间
+		if time.Since(started) > walReceiverMaxDelay {
+			delay = walReceiverMinDelay
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > walReceiverMaxDelay {
+			delay = walReceiverMaxDelay
+		}
+	}
+
+	// 退出前把已完成的段归档掉
+	s.shipSpooledWAL(context.Background(), cfg.SpoolDir)
+}
+
+func (s *Service) runReceivewal(ctx context.Context, extra ...string) error {
+	slot := s.config.WAL.Slot
+	if slot == "" {
+		slot = defaultWALSlot
+	}
+
+	args := []string{
+		"-h", s.config.Database.Host,
+		"-p", strconv.Itoa(s.config.Database.Port),
+		"-U", s.config.Database.Username,
+		"--slot", slot,
+		"--no-password",
+		"--verbose",
+	}
+	args = append(args, extra...)
+
+	cmd := exec.CommandContext(ctx, "pg_receivewal", args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.Database.Password))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
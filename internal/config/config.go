@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 )
@@ -10,6 +11,18 @@ type Config struct {
 	Database DatabaseConfig `json:"database"`
 	Storage  StorageConfig  `json:"storage"`
 	API      APIConfig      `json:"api"`
+	Dump     DumpConfig     `json:"dump"`
+
+	Compression CompressionConfig `json:"compression"`
+	WAL         WALConfig         `json:"wal"`
+
+	// Servers 可作为恢复和克隆目标的数据库服务器
+	Servers []ServerConfig `json:"servers,omitempty"`
+
+	// ClientPaths 额外搜索 pg_dump/pg_restore 的目录（支持通配符），优先于默认的多版本安装目录
+	ClientPaths []string `json:"clientPaths,omitempty"`
+
+	path string
 }
 
 type DatabaseConfig struct {
@@ -18,12 +31,38 @@ type DatabaseConfig struct {
 	Database string `json:"database" binding:"required"`
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+
+	// Replica 优先用于备份的备库，为空时从主库备份
+	Replica *ReplicaConfig `json:"replica,omitempty"`
+}
+
+// ReplicaConfig 备份使用的备库及其延迟检查
+type ReplicaConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// 为空时使用主库的用户名和密码
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// MaxLagSeconds 允许的最大复制延迟（秒），0 表示 300
+	MaxLagSeconds int `json:"maxLagSeconds"`
+	// Fallback 备库不可用或延迟超限时的策略：primary（默认）回退到主库，fail 使备份失败
+	Fallback string `json:"fallback"`
 }
 
 type StorageConfig struct {
 	Type  string      `json:"type" binding:"required,oneof=local s3"` // "local" or "s3"
 	Local LocalConfig `json:"local"`
 	S3    S3Config    `json:"s3"`
+
+	// 传输限速，单位字节/秒，0 表示不限速
+	UploadLimit   int64 `json:"uploadLimit"`
+	DownloadLimit int64 `json:"downloadLimit"`
+
+	// 单个备份文件超过该大小（字节）时切分为编号分卷，0 表示不分卷
+	VolumeSize int64 `json:"volumeSize"`
+
+	// 仓库模式：dump 按内容切块去重后存储
+	Repository bool `json:"repository"`
 }
 
 type LocalConfig struct {
@@ -34,11 +73,57 @@ type LocalConfig struct {
 }
 
 type S3Config struct {
-	Endpoint  string `json:"endpoint" binding:"required"`
-	AccessKey string `json:"accessKey" binding:"required"`
-	SecretKey string `json:"secretKey" binding:"required"`
-	Bucket    string `json:"bucket" binding:"required"`
-	Region    string `json:"region"`
+	Endpoint     string `json:"endpoint"`
+	AccessKey    string `json:"accessKey"`
+	SecretKey    string `json:"secretKey"`
+	SessionToken string `json:"sessionToken"`
+	Bucket       string `json:"bucket" binding:"required"`
+	Region       string `json:"region"`
+
+	// 未配置 accessKey 时使用 AWS 默认凭证链，profile 指定共享配置中的 profile
+	Profile string `json:"profile"`
+
+	// AssumeRole 配置，roleArn 为空时不扮演角色
+	RoleARN         string `json:"roleArn"`
+	ExternalID      string `json:"externalId"`
+	RoleSessionName string `json:"roleSessionName"`
+}
+
+// CompressionConfig 备份压缩设置
+type CompressionConfig struct {
+	Algorithm string `json:"algorithm"`         // gzip、zstd、lz4、none，为空时为 gzip
+	Level     int    `json:"level"`             // 压缩级别，0 表示使用算法默认值
+	Threads   int    `json:"threads,omitempty"` // zstd 压缩线程数
+	Method    string `json:"method,omitempty"`  // pg_dump 或 stream，为空时自动选择
+}
+
+// ServerConfig 恢复目标服务器
+type ServerConfig struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Production 为 true 时不允许在该服务器上脱敏或创建克隆
+	Production bool `json:"production"`
+}
+
+// WALConfig WAL 归档设置
+type WALConfig struct {
+	// Receive 为 true 时启动 pg_receivewal 持续接收 WAL，否则只通过 archive_command 归档
+	Receive  bool   `json:"receive"`
+	SpoolDir string `json:"spoolDir"` // pg_receivewal 的接收目录
+	Slot     string `json:"slot"`     // 复制槽名称，为空时使用 pg_backup
+
+	// WAL 的压缩设置，为空时使用 zstd
+	Compression CompressionConfig `json:"compression"`
+}
+
+// DumpConfig pg_dump 进程的资源优先级
+type DumpConfig struct {
+	Nice        int `json:"nice"`        // nice 值（-20~19），0 表示不调整
+	IONiceClass int `json:"ioniceClass"` // ionice 调度类：1 realtime、2 best-effort、3 idle，0 表示不调整
+	IONiceLevel int `json:"ioniceLevel"` // ionice 优先级（0~7），仅对 class 1/2 有效
 }
 
 type APIConfig struct {
@@ -63,10 +148,41 @@ func Load(configPath string) (*Config, error) {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	cfg.path = configPath
 
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
+// Validate 检查取值有限的配置项，避免拼写错误被静默当作默认值
+func (c *Config) Validate() error {
+	if replica := c.Database.Replica; replica != nil {
+		switch replica.Fallback {
+		case "", "primary", "fail":
+		default:
+			return fmt.Errorf("invalid replica fallback %q (supported: primary, fail)", replica.Fallback)
+		}
+	}
+	return nil
+}
+
+// Server 按名称查找恢复目标服务器，不存在时返回 nil
+func (c *Config) Server(name string) *ServerConfig {
+	for i := range c.Servers {
+		if c.Servers[i].Name == name {
+			return &c.Servers[i]
+		}
+	}
+	return nil
+}
+
+// Path 返回配置文件路径，使用默认配置时为空
+func (c *Config) Path() string {
+	return c.path
+}
+
 // Save 保存配置到文件
 func (c *Config) Save(configPath string) error {
 	// 确保配置目录存在
@@ -109,5 +225,9 @@ func loadDefaultConfig() *Config {
 		API: APIConfig{
 			Port: "8090",
 		},
+		Compression: CompressionConfig{
+			Algorithm: "gzip",
+			Level:     6,
+		},
 	}
 }
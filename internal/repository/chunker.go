@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"io"
+)
+
+// 分块大小参数：切分点由内容决定，插入或删除数据只影响附近的块
+const (
+	MinChunkSize = 512 * 1024
+	AvgChunkSize = 1024 * 1024
+	MaxChunkSize = 8 * 1024 * 1024
+)
+
+// gearTable 滚动哈希使用的随机表，必须在各版本间保持不变，否则无法跨版本去重
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x9E3779B97F4A7C15)
+	for i := range table {
+		// splitmix64
+		state += 0x9E3779B97F4A7C15
+		z := state
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker 基于 Gear 滚动哈希的内容定义分块器
+type Chunker struct {
+	r    io.Reader
+	buf  []byte
+	n    int
+	mask uint64
+	eof  bool
+}
+
+// NewChunker 创建分块器
+func NewChunker(r io.Reader) *Chunker {
+	return &Chunker{
+		r:    r,
+		buf:  make([]byte, MaxChunkSize),
+		mask: AvgChunkSize - 1,
+	}
+}
+
+// Next 返回下一个块，数据读完时返回 io.EOF
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+
+	cut := c.cutPoint(c.buf[:c.n])
+	chunk := make([]byte, cut)
+	copy(chunk, c.buf[:cut])
+
+	c.n = copy(c.buf, c.buf[cut:c.n])
+	return chunk, nil
+}
+
+// fill 尽量填满缓冲区
+func (c *Chunker) fill() error {
+	for !c.eof && c.n < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.n:])
+		c.n += n
+		if err == io.EOF {
+			c.eof = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cutPoint 在 data 中查找切分点，跳过前 MinChunkSize 字节
+func (c *Chunker) cutPoint(data []byte) int {
+	if len(data) <= MinChunkSize {
+		return len(data)
+	}
+
+	var hash uint64
+	for i := MinChunkSize; i < len(data); i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&c.mask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunkAll(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	c := NewChunker(bytes.NewReader(data))
+	var chunks [][]byte
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+func TestChunkerBoundaries(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"smaller than min", randomData(1, 1000)},
+		{"exactly min", randomData(2, MinChunkSize)},
+		{"random", randomData(3, 20*1024*1024)},
+		{"zeros", make([]byte, 3*MaxChunkSize+123)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkAll(t, tt.data)
+			if !bytes.Equal(bytes.Join(chunks, nil), tt.data) {
+				t.Fatal("chunks do not reassemble to the input")
+			}
+			if len(tt.data) == 0 && len(chunks) != 0 {
+				t.Fatalf("got %d chunks for empty input", len(chunks))
+			}
+			for i, chunk := range chunks {
+				if len(chunk) > MaxChunkSize {
+					t.Errorf("chunk %d is %d bytes, larger than max %d", i, len(chunk), MaxChunkSize)
+				}
+				if i < len(chunks)-1 && len(chunk) <= MinChunkSize {
+					t.Errorf("chunk %d is %d bytes, not larger than min %d", i, len(chunk), MinChunkSize)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkerShiftResistance(t *testing.T) {
+	data := randomData(4, 16*1024*1024)
+	shifted := append(randomData(5, 100), data...)
+
+	seen := make(map[[32]byte]bool)
+	for _, chunk := range chunkAll(t, data) {
+		seen[sha256.Sum256(chunk)] = true
+	}
+	chunks := chunkAll(t, shifted)
+	reused := 0
+	for _, chunk := range chunks {
+		if seen[sha256.Sum256(chunk)] {
+			reused++
+		}
+	}
+	// 插入的数据只影响第一个块
+	if reused < len(chunks)-2 {
+		t.Fatalf("only %d of %d chunks reused after inserting 100 bytes", reused, len(chunks))
+	}
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrLocked 仓库正被其他进程写入或清理
+var ErrLocked = errors.New("repository is locked")
+
+// 锁的类型：写入之间可以并存，prune 与任何其他锁互斥
+const (
+	lockWrite = "write"
+	lockPrune = "prune"
+)
+
+const (
+	// lockStaleAfter 超过该时长的锁视为持有者已异常退出，不再生效
+	lockStaleAfter = 24 * time.Hour
+	// lockWaitTimeout 写入等待 prune 结束的最长时间
+	lockWaitTimeout = time.Hour
+)
+
+// lockRetryDelay 写入等待 prune 结束时的重试间隔
+var lockRetryDelay = 5 * time.Second
+
+// Lock 以对象形式存放在存储中的仓库锁，对共享同一存储的所有进程生效，
+// 包括其他服务实例和 archive-wal 等子进程。获取锁时先写入自己的锁对象再检查对方的锁，
+// 写入与 prune 同时获取时至少有一方能看到另一方。
+type Lock struct {
+	repo *Repository
+	key  string
+}
+
+func (r *Repository) lockPrefix() string {
+	return path.Join(r.prefix, "locks") + "/"
+}
+
+// LockWrite 获取写入锁，仓库正在 prune 时等待其结束。新写入的块在索引被记录为存活之前
+// 对 prune 来说都是未引用的，调用方须持有锁直到索引记录完成。
+func (r *Repository) LockWrite(ctx context.Context) (*Lock, error) {
+	deadline := time.Now().Add(lockWaitTimeout)
+	for {
+		lock, err := r.createLock(ctx, lockWrite)
+		if err != nil {
+			return nil, err
+		}
+		pruning, err := r.hasLock(ctx, lockPrune, lock.key)
+		if err == nil && !pruning {
+			return lock, nil
+		}
+		lock.Release()
+		if err != nil {
+			return nil, err
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: prune still running after %s", ErrLocked, lockWaitTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryDelay):
+		}
+	}
+}
+
+// LockPrune 获取 prune 的排他锁，其他进程持有任何锁时返回 ErrLocked
+func (r *Repository) LockPrune(ctx context.Context) (*Lock, error) {
+	lock, err := r.createLock(ctx, lockPrune)
+	if err != nil {
+		return nil, err
+	}
+	busy, err := r.hasLock(ctx, "", lock.key)
+	if err == nil && !busy {
+		return lock, nil
+	}
+	lock.Release()
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrLocked
+}
+
+// Release 删除锁对象。调用方的 ctx 可能已取消，释放时不使用它。
+func (l *Lock) Release() error {
+	return l.repo.store.Delete(context.Background(), l.key)
+}
+
+// createLock 写入锁对象，类型和创建时间编码在 key 中，内容记录持有者便于排查
+func (r *Repository) createLock(ctx context.Context, kind string) (*Lock, error) {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return nil, err
+	}
+	key := r.lockPrefix() + fmt.Sprintf("%s-%d-%s", kind, time.Now().UnixNano(), hex.EncodeToString(suffix[:]))
+
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("host=%s pid=%d\n", host, os.Getpid())
+	if err := r.store.Store(ctx, key, bytes.NewReader([]byte(owner))); err != nil {
+		return nil, fmt.Errorf("failed to create repository lock: %w", err)
+	}
+	return &Lock{repo: r, key: key}, nil
+}
+
+// hasLock 检查除 self 之外是否存在指定类型的有效锁，kind 为空时匹配任意类型
+func (r *Repository) hasLock(ctx context.Context, kind, self string) (bool, error) {
+	keys, err := r.store.List(ctx, r.lockPrefix())
+	if err != nil {
+		return false, fmt.Errorf("failed to list repository locks: %w", err)
+	}
+	for _, key := range keys {
+		if key == self {
+			continue
+		}
+		parts := strings.SplitN(path.Base(key), "-", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		nanos, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || time.Since(time.Unix(0, nanos)) > lockStaleAfter {
+			continue
+		}
+		if kind == "" || parts[0] == kind {
+			return true, nil
+		}
+	}
+	return false, nil
+}
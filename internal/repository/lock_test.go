@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore 内存中的 storage.Storage，用于模拟多个进程共享同一存储
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string][]byte)}
+}
+
+func (m *memStore) Store(ctx context.Context, key string, data io.Reader) error {
+	body, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = body
+	return nil
+}
+
+func (m *memStore) Retrieve(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	body, ok := m.objects[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+func (m *memStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memStore) List(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func TestLockPrune(t *testing.T) {
+	stale := fmt.Sprintf("%d", time.Now().Add(-2*lockStaleAfter).UnixNano())
+	fresh := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	tests := []struct {
+		name    string
+		held    []string
+		wantErr error
+	}{
+		{"no locks", nil, nil},
+		{"active writer", []string{"write-" + fresh + "-a"}, ErrLocked},
+		{"active prune", []string{"prune-" + fresh + "-a"}, ErrLocked},
+		{"stale writer", []string{"write-" + stale + "-a"}, nil},
+		{"unrelated object", []string{"README"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			repo := New(store, nil, "repository")
+			for _, name := range tt.held {
+				store.objects["repository/locks/"+name] = nil
+			}
+
+			lock, err := repo.LockPrune(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LockPrune() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				// 失败时不能留下自己的锁，否则会阻塞写入
+				if keys, _ := store.List(context.Background(), "repository/locks/"); len(keys) != len(tt.held) {
+					t.Errorf("locks after failed LockPrune = %v", keys)
+				}
+				return
+			}
+			if err := lock.Release(); err != nil {
+				t.Fatalf("Release: %v", err)
+			}
+		})
+	}
+}
+
+func TestLockWriteWaitsForPrune(t *testing.T) {
+	defer func(d time.Duration) { lockRetryDelay = d }(lockRetryDelay)
+	lockRetryDelay = 10 * time.Millisecond
+
+	store := newMemStore()
+	repo := New(store, nil, "repository")
+	ctx := context.Background()
+
+	prune, err := repo.LockPrune(ctx)
+	if err != nil {
+		t.Fatalf("LockPrune: %v", err)
+	}
+
+	acquired := make(chan *Lock)
+	go func() {
+		lock, err := repo.LockWrite(ctx)
+		if err != nil {
+			t.Errorf("LockWrite: %v", err)
+		}
+		acquired <- lock
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("LockWrite returned while prune was holding the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	prune.Release()
+	write := <-acquired
+	if write == nil {
+		return
+	}
+
+	// 写入持有锁时 prune 不能开始
+	if _, err := repo.LockPrune(ctx); !errors.Is(err, ErrLocked) {
+		t.Errorf("LockPrune() with active writer error = %v, want ErrLocked", err)
+	}
+	write.Release()
+	if _, err := repo.LockPrune(ctx); err != nil {
+		t.Errorf("LockPrune() after writer released: %v", err)
+	}
+}
+
+func TestLockWriteCanceled(t *testing.T) {
+	store := newMemStore()
+	repo := New(store, nil, "repository")
+
+	if _, err := repo.LockPrune(context.Background()); err != nil {
+		t.Fatalf("LockPrune: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := repo.LockWrite(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("LockWrite() error = %v, want context.Canceled", err)
+	}
+}
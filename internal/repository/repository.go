@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"pg-backup/internal/storage"
+)
+
+// ChunkRef 索引中对单个块的引用
+type ChunkRef struct {
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+// Index 一次备份的块索引，按顺序拼接所有块即为原始数据
+type Index struct {
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Size      int64      `json:"size"`
+	Chunks    []ChunkRef `json:"chunks"`
+}
+
+// WriteStats 写入一次备份时的去重统计
+type WriteStats struct {
+	TotalChunks int   `json:"totalChunks"`
+	NewChunks   int   `json:"newChunks"`
+	NewBytes    int64 `json:"newBytes"`
+}
+
+// ChunkSet 记录仓库中已存在的块，避免每次备份都列举整个存储
+type ChunkSet interface {
+	Has(ctx context.Context, id string) (bool, error)
+	Add(ctx context.Context, ref ChunkRef, storedSize int64) error
+	Remove(ctx context.Context, id string) error
+}
+
+// Repository 内容寻址的去重备份仓库，可建立在任意 storage.Storage 之上
+type Repository struct {
+	store  storage.Storage
+	chunks ChunkSet
+	prefix string
+}
+
+// New 创建仓库，prefix 为仓库在存储中的根路径
+func New(store storage.Storage, chunks ChunkSet, prefix string) *Repository {
+	return &Repository{
+		store:  store,
+		chunks: chunks,
+		prefix: strings.TrimSuffix(prefix, "/"),
+	}
+}
+
+func (r *Repository) chunkKey(id string) string {
+	return path.Join(r.prefix, "chunks", id)
+}
+
+// IndexKey 返回索引文件在存储中的 key
+func (r *Repository) IndexKey(name string) string {
+	return path.Join(r.prefix, "index", name+".json")
+}
+
+// Write 将数据流切块后写入仓库，只上传仓库中不存在的块，最后写入索引。
+// 调用方须持有 LockWrite 返回的锁，避免其他进程的 prune 回收刚写入或复用的块。
+func (r *Repository) Write(ctx context.Context, name string, data io.Reader) (*Index, *WriteStats, error) {
+	index := &Index{Name: name, CreatedAt: time.Now()}
+	stats := &WriteStats{}
+	chunker := NewChunker(data)
+
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sum := sha256.Sum256(chunk)
+		ref := ChunkRef{ID: hex.EncodeToString(sum[:]), Size: int64(len(chunk))}
+
+		exists, err := r.chunks.Has(ctx, ref.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !exists {
+			storedSize, err := r.putChunk(ctx, ref.ID, chunk)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to store chunk %s: %w", ref.ID, err)
+			}
+			if err := r.chunks.Add(ctx, ref, storedSize); err != nil {
+				return nil, nil, err
+			}
+			stats.NewChunks++
+			stats.NewBytes += storedSize
+		}
+
+		index.Chunks = append(index.Chunks, ref)
+		index.Size += ref.Size
+		stats.TotalChunks++
+	}
+
+	body, err := json.Marshal(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := r.store.Store(ctx, r.IndexKey(name), bytes.NewReader(body)); err != nil {
+		return nil, nil, fmt.Errorf("failed to store index: %w", err)
+	}
+
+	return index, stats, nil
+}
+
+// putChunk 压缩并上传单个块，返回压缩后的大小
+func (r *Repository) putChunk(ctx context.Context, id string, chunk []byte) (int64, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(chunk); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+
+	size := int64(buf.Len())
+	return size, r.store.Store(ctx, r.chunkKey(id), &buf)
+}
+
+// ReadIndex 读取指定备份的索引
+func (r *Repository) ReadIndex(ctx context.Context, name string) (*Index, error) {
+	rc, err := r.store.Retrieve(ctx, r.IndexKey(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var index Index
+	if err := json.NewDecoder(rc).Decode(&index); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %w", name, err)
+	}
+	return &index, nil
+}
+
+// Open 按索引顺序读取并校验各个块，还原出原始数据流
+func (r *Repository) Open(ctx context.Context, index *Index) io.ReadCloser {
+	return &indexReader{ctx: ctx, repo: r, chunks: index.Chunks}
+}
+
+// DeleteIndex 删除备份索引，块由 Prune 统一回收
+func (r *Repository) DeleteIndex(ctx context.Context, name string) error {
+	return r.store.Delete(ctx, r.IndexKey(name))
+}
+
+// Prune 删除不再被任何存活索引引用的块，返回删除的块数。
+// 调用方须在确定存活索引之前通过 LockPrune 取得锁，并持有到 Prune 返回。
+func (r *Repository) Prune(ctx context.Context, live []string) (int, error) {
+	referenced := make(map[string]struct{})
+	for _, name := range live {
+		index, err := r.ReadIndex(ctx, name)
+		if err != nil {
+			// 无法确认引用关系时不能删除任何块
+			return 0, fmt.Errorf("failed to read index %s: %w", name, err)
+		}
+		for _, ref := range index.Chunks {
+			referenced[ref.ID] = struct{}{}
+		}
+	}
+
+	keys, err := r.store.List(ctx, path.Join(r.prefix, "chunks")+"/")
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, key := range keys {
+		id := path.Base(key)
+		if _, ok := referenced[id]; ok {
+			continue
+		}
+		if err := r.store.Delete(ctx, key); err != nil {
+			return removed, err
+		}
+		if err := r.chunks.Remove(ctx, id); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// indexReader 顺序读取索引中的块
+type indexReader struct {
+	ctx    context.Context
+	repo   *Repository
+	chunks []ChunkRef
+	buf    *bytes.Reader
+}
+
+func (r *indexReader) Read(p []byte) (int, error) {
+	for r.buf == nil || r.buf.Len() == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		data, err := r.repo.readChunk(r.ctx, r.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		r.chunks = r.chunks[1:]
+		r.buf = bytes.NewReader(data)
+	}
+	return r.buf.Read(p)
+}
+
+func (r *indexReader) Close() error {
+	return nil
+}
+
+func (r *Repository) readChunk(ctx context.Context, ref ChunkRef) ([]byte, error) {
+	rc, err := r.store.Retrieve(ctx, r.chunkKey(ref.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", ref.ID, err)
+	}
+	defer rc.Close()
+
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", ref.ID, err)
+	}
+	defer gz.Close()
+
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", ref.ID, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != ref.ID || int64(len(data)) != ref.Size {
+		return nil, fmt.Errorf("chunk %s is corrupted", ref.ID)
+	}
+	return data, nil
+}
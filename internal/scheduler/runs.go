@@ -0,0 +1,273 @@
+package scheduler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrJobRunning 任务正在运行，同一任务不会并发执行
+var ErrJobRunning = errors.New("job is already running")
+
+// 运行的触发方式
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+	TriggerRetry    = "retry"
+)
+
+// 运行结果
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunSkipped   = "skipped" // 触发时上一次运行尚未结束
+)
+
+const (
+	maxJobRetries    = 10
+	jobRetryDelay    = time.Minute
+	jobStatsWindow   = 30 * 24 * time.Hour
+	defaultRunsLimit = 50
+)
+
+// JobRun 定时任务的一次运行
+type JobRun struct {
+	ID         int64      `json:"id"`
+	JobID      int64      `json:"jobId"`
+	BackupID   int64      `json:"backupId,omitempty"`
+	Trigger    string     `json:"trigger"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+}
+
+// JobStats 最近 30 天的运行统计，成功率和平均耗时只计算已结束的运行
+type JobStats struct {
+	Runs        int     `json:"runs"`
+	SuccessRate float64 `json:"successRate"`
+	AvgDuration string  `json:"avgDuration,omitempty"`
+	// LastFailure 最近一次失败的原因，不限于统计窗口
+	LastFailure   string     `json:"lastFailure,omitempty"`
+	LastFailureAt *time.Time `json:"lastFailureAt,omitempty"`
+}
+
+// runJob 由 cron 触发执行任务。同一任务同时只有一次运行，上一次运行尚未结束时本次记为 skipped。
+func (s *Service) runJob(jobID int64, trigger string) {
+	if !s.acquire(jobID) {
+		s.logger.Printf("Job %d is still running, skipping this run", jobID)
+		s.recordSkippedRun(jobID, trigger)
+		return
+	}
+	s.execute(jobID, trigger)
+}
+
+// RunJobNow 在后台立即运行一次任务，与定时触发走相同的流程并记为手动运行。
+// 任务正在运行时返回 ErrJobRunning，已暂停的任务也可以手动运行。
+func (s *Service) RunJobNow(jobID int64) error {
+	if _, err := s.GetJob(jobID); err != nil {
+		return err
+	}
+	if !s.acquire(jobID) {
+		return ErrJobRunning
+	}
+	go s.execute(jobID, TriggerManual)
+	return nil
+}
+
+// execute 读取最新的任务定义，按其备份参数备份，失败时按 Retries 重试，成功后执行保留策略。
+// 调用前须已通过 acquire 标记任务运行，结束时释放。
+func (s *Service) execute(jobID int64, trigger string) {
+	defer s.release(jobID)
+
+	job, err := s.GetJob(jobID)
+	if err != nil {
+		s.logger.Printf("Backup run failed for job %d: %v", jobID, err)
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := s.runAttempt(job, trigger); err == nil || attempt >= job.Retries {
+			return
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(jobRetryDelay):
+		}
+		trigger = TriggerRetry
+	}
+}
+
+// acquire 标记任务开始运行，任务已在运行时返回 false
+func (s *Service) acquire(jobID int64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.running[jobID] {
+		return false
+	}
+	s.running[jobID] = true
+	return true
+}
+
+func (s *Service) release(jobID int64) {
+	s.mutex.Lock()
+	delete(s.running, jobID)
+	s.mutex.Unlock()
+}
+
+// runAttempt 执行一次备份并记录运行结果
+func (s *Service) runAttempt(job *ScheduledJob, trigger string) error {
+	var runID int64
+	err := s.db.QueryRow(`
+		INSERT INTO job_runs (job_id, trigger_type, status) VALUES ($1, $2, $3)
+		RETURNING id
+	`, job.ID, trigger, RunRunning).Scan(&runID)
+	if err != nil {
+		s.logger.Printf("Failed to record run of job %d: %v", job.ID, err)
+	}
+
+	opts := job.backupOptions()
+	opts.OnRecord = func(backupID int64) {
+		if _, err := s.db.Exec("UPDATE job_runs SET backup_id = $1 WHERE id = $2", backupID, runID); err != nil {
+			s.logger.Printf("Failed to link run %d of job %d to backup %d: %v", runID, job.ID, backupID, err)
+		}
+	}
+	backupErr := s.backupService.CreateBackup(opts)
+
+	status, errMsg := RunSucceeded, ""
+	if backupErr != nil {
+		status, errMsg = RunFailed, backupErr.Error()
+		s.logger.Printf("Backup run (%s) failed for job %d: %v", trigger, job.ID, backupErr)
+	}
+	if _, err := s.db.Exec(`
+		UPDATE job_runs SET status = $1, error = NULLIF($2, ''), finished_at = CURRENT_TIMESTAMP WHERE id = $3
+	`, status, errMsg, runID); err != nil {
+		s.logger.Printf("Failed to record result of run %d of job %d: %v", runID, job.ID, err)
+	}
+	if _, err := s.db.Exec("UPDATE scheduled_jobs SET last_run = CURRENT_TIMESTAMP WHERE id = $1", job.ID); err != nil {
+		s.logger.Printf("Failed to update last run time for job %d: %v", job.ID, err)
+	}
+
+	if backupErr == nil && job.Retention != nil {
+		deleted, err := s.backupService.ExpireJobBackups(s.ctx, job.ID, job.Retention)
+		if err != nil {
+			s.logger.Printf("Failed to apply retention policy of job %d: %v", job.ID, err)
+		} else if deleted > 0 {
+			s.logger.Printf("Retention policy of job %d deleted %d backups", job.ID, deleted)
+		}
+	}
+	return backupErr
+}
+
+// recordSkippedRun 记录因上一次运行尚未结束而跳过的运行
+func (s *Service) recordSkippedRun(jobID int64, trigger string) {
+	if _, err := s.db.Exec(`
+		INSERT INTO job_runs (job_id, trigger_type, status, error, finished_at)
+		VALUES ($1, $2, $3, 'previous run still in progress', CURRENT_TIMESTAMP)
+	`, jobID, trigger, RunSkipped); err != nil {
+		s.logger.Printf("Failed to record skipped run of job %d: %v", jobID, err)
+	}
+}
+
+// failInterruptedRuns 启动时将上次进程退出时仍在运行的记录标记为失败
+func (s *Service) failInterruptedRuns() {
+	res, err := s.db.Exec(`
+		UPDATE job_runs SET status = $1, error = 'interrupted by server restart', finished_at = CURRENT_TIMESTAMP
+		WHERE status = $2
+	`, RunFailed, RunRunning)
+	if err != nil {
+		s.logger.Printf("Failed to mark interrupted job runs: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		s.logger.Printf("Marked %d interrupted job runs as failed", n)
+	}
+}
+
+// GetJobRuns 返回任务最近的运行记录，limit 为 0 时返回 50 条，任务不存在时返回 ErrJobNotFound
+func (s *Service) GetJobRuns(jobID int64, limit int) ([]JobRun, error) {
+	if limit == 0 {
+		limit = defaultRunsLimit
+	}
+	var exists bool
+	if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM scheduled_jobs WHERE id = $1)", jobID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %d", ErrJobNotFound, jobID)
+	}
+	rows, err := s.db.Query(`
+		SELECT id, job_id, COALESCE(backup_id, 0), trigger_type, status, COALESCE(error, ''), started_at, finished_at
+		FROM job_runs
+		WHERE job_id = $1
+		ORDER BY started_at DESC, id DESC
+		LIMIT $2
+	`, jobID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []JobRun{}
+	for rows.Next() {
+		var run JobRun
+		var finished sql.NullTime
+		if err := rows.Scan(&run.ID, &run.JobID, &run.BackupID, &run.Trigger, &run.Status, &run.Error,
+			&run.StartedAt, &finished); err != nil {
+			return nil, err
+		}
+		if finished.Valid {
+			run.FinishedAt = &finished.Time
+			run.Duration = finished.Time.Sub(run.StartedAt).Round(time.Second).String()
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// jobStats 统计任务的运行情况，jobID 为 0 时统计所有任务
+func (s *Service) jobStats(jobID int64) (map[int64]*JobStats, error) {
+	rows, err := s.db.Query(`
+		SELECT job_id,
+		       COUNT(*) FILTER (WHERE status IN ('succeeded', 'failed') AND started_at > $1),
+		       COUNT(*) FILTER (WHERE status = 'succeeded' AND started_at > $1),
+		       AVG(EXTRACT(EPOCH FROM finished_at - started_at)) FILTER (WHERE status = 'succeeded' AND started_at > $1),
+		       (ARRAY_AGG(error ORDER BY started_at DESC) FILTER (WHERE status = 'failed'))[1],
+		       MAX(started_at) FILTER (WHERE status = 'failed')
+		FROM job_runs
+		WHERE $2::bigint = 0 OR job_id = $2
+		GROUP BY job_id
+	`, time.Now().Add(-jobStatsWindow), jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[int64]*JobStats)
+	for rows.Next() {
+		var id int64
+		var finished, succeeded int
+		var avg sql.NullFloat64
+		var lastFailure sql.NullString
+		var lastFailureAt sql.NullTime
+		if err := rows.Scan(&id, &finished, &succeeded, &avg, &lastFailure, &lastFailureAt); err != nil {
+			return nil, err
+		}
+		st := &JobStats{Runs: finished, LastFailure: lastFailure.String}
+		if finished > 0 {
+			st.SuccessRate = float64(succeeded) / float64(finished)
+		}
+		if avg.Valid {
+			st.AvgDuration = time.Duration(avg.Float64 * float64(time.Second)).Round(time.Second).String()
+		}
+		if lastFailureAt.Valid {
+			st.LastFailureAt = &lastFailureAt.Time
+		}
+		stats[id] = st
+	}
+	return stats, rows.Err()
+}
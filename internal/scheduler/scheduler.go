@@ -3,6 +3,9 @@ package scheduler
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
@@ -12,33 +15,65 @@ import (
 	"github.com/robfig/cron/v3"
 )
 
+var (
+	// ErrVersionConflict 更新任务时版本号与数据库中的不一致，任务已被其他请求修改
+	ErrVersionConflict = errors.New("job was modified by another request, reload and retry")
+	// ErrJobNotFound 任务不存在
+	ErrJobNotFound = errors.New("job not found")
+	// ErrInvalidJob 任务的调度表达式、备份参数或保留策略无效
+	ErrInvalidJob = errors.New("invalid job")
+)
+
 // Logger 接口用于解耦日志实现
 type Logger interface {
 	Printf(format string, v ...interface{})
 }
 
-// Service 定时任务服务
+// Service 定时任务服务，所有任务共用一个 cron 引擎
 type Service struct {
 	db            *sql.DB
 	backupService *backup.Service
-	cronJobs      map[int64]*cron.Cron
+	cron          *cron.Cron
+	entries       map[int64]cronEntry // 已调度任务的 cron 条目
+	running       map[int64]bool      // 正在执行的任务，同一任务不会并发执行
 	mutex         sync.RWMutex
-	logger        Logger // 日志接口
+	changeMu      sync.Mutex // 串行化任务的修改，保证 cron 条目与数据库中最新的任务一致
+	logger        Logger     // 日志接口
 	ctx           context.Context
 	cancel        context.CancelFunc
 }
 
+// cronEntry 任务在 cron 引擎中的条目及其调度表达式
+type cronEntry struct {
+	id       cron.EntryID
+	schedule string
+}
+
 // ScheduledJob 定义定时任务结构
 type ScheduledJob struct {
-	ID           int64
-	Name         string
-	Type         string
-	Schedule     string
-	ScheduleText string
-	Enabled      bool
-	LastRun      string
-	NextRun      string
-	Status       string
+	ID   int64  `json:"id"`
+	Name string `json:"name" binding:"required"`
+	// Type 创建任务时选择的存储类型（local 或 s3），仅用于展示；备份写入的存储以 Options.Destination 为准，
+	// 为空时使用当前配置的存储
+	Type         string `json:"type"`
+	Schedule     string `json:"schedule" binding:"required"`
+	ScheduleText string `json:"scheduleText"`
+	Enabled      bool   `json:"enabled"`
+	LastRun      string `json:"lastRun"`
+	NextRun      string `json:"nextRun"`
+	Status       string `json:"status"`
+	// Version 每次修改加 1，更新时需提供读取到的版本
+	Version int `json:"version"`
+	// Retries 运行失败后的重试次数，每次间隔 1 分钟
+	Retries int `json:"retries"`
+
+	// Options 每次运行使用的完整备份参数：数据库、格式、压缩、对象范围、钩子等
+	Options backup.Options `json:"options"`
+	// Retention 任务创建的备份的保留策略，为空时不清理
+	Retention *backup.RetentionPolicy `json:"retention,omitempty"`
+
+	// Stats 最近的运行统计，没有运行记录时为空
+	Stats *JobStats `json:"stats,omitempty"`
 }
 
 // New 创建一个新的调度服务实例
@@ -47,7 +82,9 @@ func New(db *sql.DB, backupService *backup.Service) *Service {
 	return &Service{
 		db:            db,
 		backupService: backupService,
-		cronJobs:      make(map[int64]*cron.Cron),
+		cron:          cron.New(),
+		entries:       make(map[int64]cronEntry),
+		running:       make(map[int64]bool),
 		logger:        log.Default(),
 		ctx:           ctx,
 		cancel:        cancel,
@@ -59,9 +96,11 @@ func (s *Service) SetLogger(logger Logger) {
 	s.logger = logger
 }
 
-// Start 启动定时服务，加载已启用的任务
+// Start 启动 cron 引擎并加载已启用的任务
 func (s *Service) Start() error {
 	s.logger.Printf("Starting scheduler service...")
+	s.failInterruptedRuns()
+	s.cron.Start()
 	if err := s.LoadJobs(); err != nil {
 		s.logger.Printf("Failed to load scheduled jobs: %v", err)
 		return err
@@ -69,57 +108,124 @@ func (s *Service) Start() error {
 	return nil
 }
 
-// Stop 停止所有定时任务（优雅关闭）
+// Stop 停止 cron 引擎（优雅关闭），不再触发新的运行
 func (s *Service) Stop() {
 	s.logger.Printf("Stopping scheduler service...")
 
 	s.cancel() // 触发 context.Done()
+	s.cron.Stop()
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-
-	for id, cronJob := range s.cronJobs {
-		cronJob.Stop()
-		delete(s.cronJobs, id)
+	for id, entry := range s.entries {
+		s.cron.Remove(entry.id)
+		delete(s.entries, id)
 	}
 
 	s.logger.Printf("Scheduler stopped.")
 }
 
+// validateJob 校验 cron 表达式、备份参数和保留策略，错误包装 ErrInvalidJob
+func (s *Service) validateJob(job *ScheduledJob) error {
+	if _, err := cron.ParseStandard(job.Schedule); err != nil {
+		return fmt.Errorf("%w: invalid schedule %q: %v", ErrInvalidJob, job.Schedule, err)
+	}
+	if err := s.backupService.ValidateOptions(job.backupOptions()); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidJob, err)
+	}
+	if job.Retries < 0 || job.Retries > maxJobRetries {
+		return fmt.Errorf("%w: retries must be between 0 and %d", ErrInvalidJob, maxJobRetries)
+	}
+	if err := job.Retention.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidJob, err)
+	}
+	return nil
+}
+
 // CreateJob 创建定时任务
 func (s *Service) CreateJob(job *ScheduledJob) error {
-	// 验证 cron 表达式
-	if _, err := cron.ParseStandard(job.Schedule); err != nil {
+	if err := s.validateJob(job); err != nil {
+		return err
+	}
+	options, err := json.Marshal(job.Options)
+	if err != nil {
+		return err
+	}
+	retention, err := marshalRetention(job.Retention)
+	if err != nil {
 		return err
 	}
 
-	// 保存到数据库
-	err := s.db.QueryRow(`
-		INSERT INTO scheduled_jobs (name, type, schedule, schedule_text, enabled)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id
-	`, job.Name, job.Type, job.Schedule, job.ScheduleText, job.Enabled).Scan(&job.ID)
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
 
+	// 保存到数据库
+	err = s.db.QueryRow(`
+		INSERT INTO scheduled_jobs (name, type, schedule, schedule_text, enabled, options, retention, retries)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, version
+	`, job.Name, job.Type, job.Schedule, job.ScheduleText, job.Enabled, string(options), retention, job.Retries).
+		Scan(&job.ID, &job.Version)
 	if err != nil {
 		return err
 	}
 
 	// 如果启用，添加到调度器
 	if job.Enabled {
-		s.addCronJob(job.ID, job.Schedule)
+		return s.schedule(job.ID, job.Schedule)
 	}
-
 	return nil
 }
 
+const jobColumns = `
+	id, name, COALESCE(type, ''), schedule, COALESCE(schedule_text, ''), enabled,
+	COALESCE(to_char(last_run, 'YYYY-MM-DD HH24:MI:SS'), '从未运行'), version, retries, options, retention
+`
+
+// scanJob 读取一行任务记录
+func scanJob(row interface{ Scan(...interface{}) error }) (*ScheduledJob, error) {
+	var job ScheduledJob
+	var options, retention []byte
+	err := row.Scan(&job.ID, &job.Name, &job.Type, &job.Schedule,
+		&job.ScheduleText, &job.Enabled, &job.LastRun, &job.Version, &job.Retries, &options, &retention)
+	if err != nil {
+		return nil, err
+	}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &job.Options); err != nil {
+			return nil, fmt.Errorf("invalid options of job %d: %w", job.ID, err)
+		}
+	}
+	if len(retention) > 0 {
+		job.Retention = &backup.RetentionPolicy{}
+		if err := json.Unmarshal(retention, job.Retention); err != nil {
+			return nil, fmt.Errorf("invalid retention of job %d: %w", job.ID, err)
+		}
+	}
+	return &job, nil
+}
+
+// GetJob 获取单个定时任务
+func (s *Service) GetJob(id int64) (*ScheduledJob, error) {
+	job, err := scanJob(s.db.QueryRow("SELECT "+jobColumns+" FROM scheduled_jobs WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrJobNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.fillStatus(job)
+	stats, err := s.jobStats(job.ID)
+	if err != nil {
+		return nil, err
+	}
+	job.Stats = stats[job.ID]
+	return job, nil
+}
+
 // GetJobs 获取所有定时任务
 func (s *Service) GetJobs() ([]ScheduledJob, error) {
-	rows, err := s.db.Query(`
-		SELECT id, name, type, schedule, COALESCE(schedule_text, ''), enabled,
-		       COALESCE(to_char(last_run, 'YYYY-MM-DD HH24:MI:SS'), '从未运行')
-		FROM scheduled_jobs 
-		ORDER BY id DESC
-	`)
+	rows, err := s.db.Query("SELECT " + jobColumns + " FROM scheduled_jobs ORDER BY id DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -127,80 +233,152 @@ func (s *Service) GetJobs() ([]ScheduledJob, error) {
 
 	var jobs []ScheduledJob
 	for rows.Next() {
-		var job ScheduledJob
-		err := rows.Scan(&job.ID, &job.Name, &job.Type, &job.Schedule,
-			&job.ScheduleText, &job.Enabled, &job.LastRun)
+		job, err := scanJob(rows)
 		if err != nil {
-			continue
+			return nil, err
 		}
+		s.fillStatus(job)
+		jobs = append(jobs, *job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats, err := s.jobStats(0)
+	if err != nil {
+		return nil, err
+	}
+	for i := range jobs {
+		jobs[i].Stats = stats[jobs[i].ID]
+	}
+	return jobs, nil
+}
 
-		// 计算下次运行时间
-		if job.Enabled {
-			job.Status = "active"
-			if parsed, err := cron.ParseStandard(job.Schedule); err == nil {
-				job.NextRun = parsed.Next(time.Now()).Format("2006-01-02 15:04:05")
-			} else {
-				job.NextRun = "计算失败"
-			}
-		} else {
-			job.Status = "paused"
-			job.NextRun = "已暂停"
+// fillStatus 填写任务状态和下次运行时间，已调度的任务以 cron 引擎中的条目为准
+func (s *Service) fillStatus(job *ScheduledJob) {
+	if !job.Enabled {
+		job.Status = "paused"
+		job.NextRun = "已暂停"
+		return
+	}
+	job.Status = "active"
+
+	s.mutex.RLock()
+	entry, scheduled := s.entries[job.ID]
+	s.mutex.RUnlock()
+	if scheduled {
+		if next := s.cron.Entry(entry.id).Next; !next.IsZero() {
+			job.NextRun = next.Format("2006-01-02 15:04:05")
+			return
 		}
+	}
+	if parsed, err := cron.ParseStandard(job.Schedule); err == nil {
+		job.NextRun = parsed.Next(time.Now()).Format("2006-01-02 15:04:05")
+	} else {
+		job.NextRun = "计算失败"
+	}
+}
 
-		jobs = append(jobs, job)
+// UpdateJob 更新定时任务。job.Version 须为读取时的版本，与数据库不一致时返回 ErrVersionConflict；
+// 成功后 job.Version 为新版本，并按新的调度表达式和启用状态更新 cron 条目。
+func (s *Service) UpdateJob(job *ScheduledJob) error {
+	if job.Version <= 0 {
+		return fmt.Errorf("%w: version is required", ErrInvalidJob)
+	}
+	if err := s.validateJob(job); err != nil {
+		return err
+	}
+	options, err := json.Marshal(job.Options)
+	if err != nil {
+		return err
+	}
+	retention, err := marshalRetention(job.Retention)
+	if err != nil {
+		return err
 	}
 
-	return jobs, nil
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
+	err = s.db.QueryRow(`
+		UPDATE scheduled_jobs
+		SET name = $1, type = $2, schedule = $3, schedule_text = $4, enabled = $5, options = $6, retention = $7,
+		    retries = $8, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $9 AND version = $10
+		RETURNING version
+	`, job.Name, job.Type, job.Schedule, job.ScheduleText, job.Enabled, string(options), retention, job.Retries,
+		job.ID, job.Version).Scan(&job.Version)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM scheduled_jobs WHERE id = $1)", job.ID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: %d", ErrJobNotFound, job.ID)
+		}
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	// 调度表达式未变时保留原条目，不会错过或重复触发
+	if job.Enabled {
+		return s.schedule(job.ID, job.Schedule)
+	}
+	s.unschedule(job.ID)
+	return nil
 }
 
 // ToggleJob 切换任务状态
 func (s *Service) ToggleJob(id int64) (bool, error) {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
 	var enabled bool
-	err := s.db.QueryRow("SELECT enabled FROM scheduled_jobs WHERE id = $1", id).Scan(&enabled)
-	if err != nil {
-		return false, err
+	var schedule string
+	err := s.db.QueryRow(`
+		UPDATE scheduled_jobs SET enabled = NOT enabled, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING enabled, schedule
+	`, id).Scan(&enabled, &schedule)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("%w: %d", ErrJobNotFound, id)
 	}
-
-	newStatus := !enabled
-	_, err = s.db.Exec("UPDATE scheduled_jobs SET enabled = $1 WHERE id = $2", newStatus, id)
 	if err != nil {
 		return false, err
 	}
 
-	s.mutex.Lock()
-	if cronJob, exists := s.cronJobs[id]; exists {
-		cronJob.Stop()
-		delete(s.cronJobs, id)
+	if enabled {
+		return enabled, s.schedule(id, schedule)
 	}
-	s.mutex.Unlock()
-
-	if newStatus {
-		var schedule, jobType string
-		s.db.QueryRow("SELECT schedule, type FROM scheduled_jobs WHERE id = $1", id).Scan(&schedule, &jobType)
-		s.addCronJob(id, schedule)
-	}
-
-	return newStatus, nil
+	s.unschedule(id)
+	return enabled, nil
 }
 
-// DeleteJob 删除定时任务
+// DeleteJob 删除定时任务，任务不存在时返回 ErrJobNotFound
 func (s *Service) DeleteJob(id int64) error {
-	// 停止并删除 cron 任务
-	s.mutex.Lock()
-	if cronJob, exists := s.cronJobs[id]; exists {
-		cronJob.Stop()
-		delete(s.cronJobs, id)
-	}
-	s.mutex.Unlock()
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
+	s.unschedule(id)
 
 	// 从数据库删除
-	_, err := s.db.Exec("DELETE FROM scheduled_jobs WHERE id = $1", id)
-	return err
+	result, err := s.db.Exec("DELETE FROM scheduled_jobs WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: %d", ErrJobNotFound, id)
+	}
+	return nil
 }
 
 // LoadJobs 加载所有启用的定时任务
 func (s *Service) LoadJobs() error {
-	rows, err := s.db.Query("SELECT id, schedule, type FROM scheduled_jobs WHERE enabled = true")
+	rows, err := s.db.Query("SELECT id, schedule FROM scheduled_jobs WHERE enabled = true")
 	if err != nil {
 		return err
 	}
@@ -208,43 +386,62 @@ func (s *Service) LoadJobs() error {
 
 	for rows.Next() {
 		var id int64
-		var schedule, jobType string
-		if err := rows.Scan(&id, &schedule, &jobType); err == nil {
-			s.addCronJob(id, schedule)
+		var schedule string
+		if err := rows.Scan(&id, &schedule); err != nil {
+			return err
+		}
+		if err := s.schedule(id, schedule); err != nil {
+			s.logger.Printf("Failed to schedule job %d: %v", id, err)
 		}
 	}
+	return rows.Err()
+}
 
+// schedule 在 cron 引擎中添加或替换任务的条目，调度表达式未变时保持原条目
+func (s *Service) schedule(jobID int64, schedule string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry, ok := s.entries[jobID]; ok {
+		if entry.schedule == schedule {
+			return nil
+		}
+		s.cron.Remove(entry.id)
+		delete(s.entries, jobID)
+	}
+	entryID, err := s.cron.AddFunc(schedule, func() { s.runJob(jobID, TriggerSchedule) })
+	if err != nil {
+		return fmt.Errorf("failed to schedule job %d: %w", jobID, err)
+	}
+	s.entries[jobID] = cronEntry{id: entryID, schedule: schedule}
 	return nil
 }
 
-// addCronJob 添加定时任务到调度器
-func (s *Service) addCronJob(jobID int64, schedule string) {
+// unschedule 从 cron 引擎中移除任务，正在执行的运行不受影响
+func (s *Service) unschedule(jobID int64) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	cronInstance := cron.New()
-	_, err := cronInstance.AddFunc(schedule, func() {
-		// 删除未使用的 ctx 声明
-		// ctx := context.Background()
-
-		// 执行备份
-		if err := s.backupService.CreateBackup(true, true, true); err != nil {
-			log.Printf("Scheduled backup failed for job %d: %v", jobID, err)
-		}
+	if entry, ok := s.entries[jobID]; ok {
+		s.cron.Remove(entry.id)
+		delete(s.entries, jobID)
+	}
+}
 
-		// 更新最后运行时间，使用 ExecContext
-		ctx := context.Background()
-		_, err := s.db.ExecContext(ctx, "UPDATE scheduled_jobs SET last_run = CURRENT_TIMESTAMP WHERE id = $1", jobID)
-		if err != nil {
-			log.Printf("Failed to update last run time for job %d: %v", jobID, err)
-		}
-	})
+// backupOptions 任务每次运行的备份参数
+func (job *ScheduledJob) backupOptions() backup.Options {
+	opts := job.Options
+	opts.JobID = job.ID
+	return opts
+}
 
+func marshalRetention(policy *backup.RetentionPolicy) (interface{}, error) {
+	if policy == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(policy)
 	if err != nil {
-		log.Printf("Failed to add cron job %d: %v", jobID, err)
-		return
+		return nil, err
 	}
-
-	cronInstance.Start()
-	s.cronJobs[jobID] = cronInstance
+	return string(data), nil
 }
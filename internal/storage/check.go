@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// CheckStep 连通性测试中单个步骤的结果
+type CheckStep struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	DurationMs int64  `json:"durationMs"`
+	ErrorCode  string `json:"errorCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// CheckResult 存储连通性测试结果
+type CheckResult struct {
+	Type    string      `json:"type"`
+	Target  string      `json:"target"`
+	Key     string      `json:"key"`
+	Success bool        `json:"success"`
+	Steps   []CheckStep `json:"steps"`
+}
+
+// TestConnection 通过写入、读取、删除一个探测对象验证存储是否可用
+func (s *Service) TestConnection(ctx context.Context) *CheckResult {
+	result := &CheckResult{
+		Type:   s.config.Type,
+		Target: s.describeTarget(),
+		Key:    probeKey(),
+	}
+	payload := []byte("pg-backup connectivity probe " + time.Now().Format(time.RFC3339Nano))
+
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"put", func() error {
+			return s.Store(ctx, result.Key, bytes.NewReader(payload))
+		}},
+		{"get", func() error {
+			rc, err := s.Retrieve(ctx, result.Key)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, payload) {
+				return fmt.Errorf("content mismatch: wrote %d bytes, read %d bytes", len(payload), len(got))
+			}
+			return nil
+		}},
+		{"delete", func() error {
+			return s.Delete(ctx, result.Key)
+		}},
+	}
+
+	result.Success = true
+	for _, step := range steps {
+		start := time.Now()
+		err := step.fn()
+		cs := CheckStep{
+			Name:       step.name,
+			OK:         err == nil,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			cs.ErrorCode, cs.Error = describeError(err)
+		}
+		result.Steps = append(result.Steps, cs)
+
+		if err != nil {
+			result.Success = false
+			// 写入失败时没有需要清理的对象；读取失败时仍尝试删除探测对象
+			if step.name == "put" {
+				break
+			}
+			if step.name == "get" {
+				s.Delete(ctx, result.Key)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+func (s *Service) describeTarget() string {
+	switch s.config.Type {
+	case "local":
+		return s.config.Local.BackupPath
+	case "s3":
+		if s.config.S3.Endpoint != "" {
+			return fmt.Sprintf("s3://%s (%s)", s.config.S3.Bucket, s.config.S3.Endpoint)
+		}
+		return fmt.Sprintf("s3://%s", s.config.S3.Bucket)
+	default:
+		return ""
+	}
+}
+
+// describeError 提取 S3 错误码，便于区分凭证、权限、桶不存在等问题
+func describeError(err error) (string, string) {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorMessage() != "" {
+		return apiErr.ErrorCode(), apiErr.ErrorMessage()
+	}
+	if apiErr != nil {
+		return apiErr.ErrorCode(), err.Error()
+	}
+	return "", err.Error()
+}
+
+func probeKey() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return fmt.Sprintf(".pg-backup-probe/%s", hex.EncodeToString(buf))
+}
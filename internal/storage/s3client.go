@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"pg-backup/internal/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const defaultRoleSessionName = "pg-backup"
+
+// NewS3Client 根据配置创建 S3 客户端
+//
+// 凭证解析顺序：
+//  1. 配置了 accessKey/secretKey 时使用静态凭证
+//  2. 否则使用 AWS 默认凭证链（环境变量、共享配置 profile、Web Identity、ECS/EC2 IMDS）
+//
+// 如果配置了 roleArn，会在上述基础凭证之上再执行 AssumeRole（可带 externalId）。
+func NewS3Client(ctx context.Context, cfg *config.S3Config) (*s3.Client, error) {
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(cfg.Region),
+	}
+	if cfg.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(cfg.Profile))
+	}
+	if cfg.AccessKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			cfg.AccessKey,
+			cfg.SecretKey,
+			cfg.SessionToken,
+		)))
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	// 在基础凭证之上扮演角色
+	if cfg.RoleARN != "" {
+		sessionName := cfg.RoleSessionName
+		if sessionName == "" {
+			sessionName = defaultRoleSessionName
+		}
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), cfg.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			if cfg.ExternalID != "" {
+				o.ExternalID = aws.String(cfg.ExternalID)
+			}
+		})
+		awsCfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+}
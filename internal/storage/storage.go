@@ -10,6 +10,7 @@ import (
 	"pg-backup/internal/config"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
@@ -35,28 +36,77 @@ func (s *Service) SetS3Client(client *s3.Client) {
 	s.s3Client = client
 }
 
-// Store 存储文件
+// TransferOptions 单次传输的限速与统计选项
+type TransferOptions struct {
+	// RateLimit 限速（字节/秒）：0 使用存储配置中的默认值，负数表示不限速
+	RateLimit int64
+	// Stats 可选，用于外部观察传输进度
+	Stats *TransferStats
+}
+
+// Store 存储文件，使用配置中的上传限速
 func (s *Service) Store(ctx context.Context, key string, data io.Reader) error {
+	return s.Upload(ctx, key, data, -1, TransferOptions{})
+}
+
+// Retrieve 获取文件，使用配置中的下载限速
+func (s *Service) Retrieve(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.Download(ctx, key, TransferOptions{})
+}
+
+// Upload 按限速上传数据，size 未知时传入 -1
+func (s *Service) Upload(ctx context.Context, key string, data io.Reader, size int64, opts TransferOptions) error {
+	if size < 0 {
+		if seeker, ok := data.(io.Seeker); ok {
+			size = remainingSize(seeker)
+		}
+	}
+	// 长度未知的流先落盘，PutObject 需要 Content-Length；限速和统计作用于落盘后的上传
+	if s.config.Type == "s3" && size < 0 {
+		tmp, n, err := spoolTemp(data)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		data, size = tmp, n
+	}
+	limit := resolveLimit(opts.RateLimit, s.config.UploadLimit)
+	body := NewMeteredReader(ctx, data, limit, opts.Stats)
+
 	switch s.config.Type {
 	case "local":
-		return s.storeLocal(key, data)
+		return s.storeLocal(key, body)
 	case "s3":
-		return s.storeS3(ctx, key, data)
+		return s.storeS3(ctx, key, body, size)
 	default:
 		return fmt.Errorf("unsupported storage type: %s", s.config.Type)
 	}
 }
 
-// Retrieve 获取文件
-func (s *Service) Retrieve(ctx context.Context, key string) (io.ReadCloser, error) {
+// Download 按限速读取数据
+func (s *Service) Download(ctx context.Context, key string, opts TransferOptions) (io.ReadCloser, error) {
+	var (
+		rc  io.ReadCloser
+		err error
+	)
 	switch s.config.Type {
 	case "local":
-		return s.retrieveLocal(key)
+		rc, err = s.retrieveLocal(key)
 	case "s3":
-		return s.retrieveS3(ctx, key)
+		rc, err = s.retrieveS3(ctx, key)
 	default:
 		return nil, fmt.Errorf("unsupported storage type: %s", s.config.Type)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	limit := resolveLimit(opts.RateLimit, s.config.DownloadLimit)
+	return &meteredReadCloser{
+		Reader: NewMeteredReader(ctx, rc, limit, opts.Stats),
+		closer: rc,
+	}, nil
 }
 
 // Delete 删除文件
@@ -131,19 +181,39 @@ func (s *Service) listLocal(prefix string) ([]string, error) {
 }
 
 // S3存储实现
-func (s *Service) storeS3(ctx context.Context, key string, data io.Reader) error {
+func (s *Service) storeS3(ctx context.Context, key string, data io.Reader, size int64) error {
 	if s.s3Client == nil {
 		return fmt.Errorf("S3 client not initialized")
 	}
 
+	// 限速后的流不可回退，跳过载荷签名以避免为计算哈希提前读取整个流
 	_, err := s.s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(s.config.S3.Bucket),
-		Key:    aws.String(key),
-		Body:   data,
-	})
+		Bucket:        aws.String(s.config.S3.Bucket),
+		Key:           aws.String(key),
+		Body:          data,
+		ContentLength: size,
+	}, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))
 	return err
 }
 
+// spoolTemp 将流写入临时文件并回到文件开头，返回文件和长度，出错时删除临时文件
+func spoolTemp(data io.Reader) (*os.File, int64, error) {
+	tmp, err := os.CreateTemp("", "pg-backup-upload-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := io.Copy(tmp, data)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, err
+	}
+	return tmp, size, nil
+}
+
 func (s *Service) retrieveS3(ctx context.Context, key string) (io.ReadCloser, error) {
 	if s.s3Client == nil {
 		return nil, fmt.Errorf("S3 client not initialized")
@@ -176,16 +246,46 @@ func (s *Service) listS3(ctx context.Context, prefix string) ([]string, error) {
 		return nil, fmt.Errorf("S3 client not initialized")
 	}
 
-	result, err := s.s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+	paginator := s3.NewListObjectsV2Paginator(s.s3Client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.config.S3.Bucket),
 		Prefix: aws.String(prefix),
 	})
-	if err != nil {
-		return nil, err
-	}
+
 	var keys []string
-	for _, obj := range result.Contents {
-		keys = append(keys, *obj.Key)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, *obj.Key)
+		}
 	}
 	return keys, nil
-}
\ No newline at end of file
+}
+
+func resolveLimit(override, configured int64) int64 {
+	switch {
+	case override < 0:
+		return 0
+	case override > 0:
+		return override
+	default:
+		return configured
+	}
+}
+
+func remainingSize(seeker io.Seeker) int64 {
+	cur, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1
+	}
+	if _, err := seeker.Seek(cur, io.SeekStart); err != nil {
+		return -1
+	}
+	return end - cur
+}
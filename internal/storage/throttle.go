@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransferStats 记录一次传输的字节数与速率，可被并发读取
+type TransferStats struct {
+	bytes     atomic.Int64
+	limit     atomic.Int64
+	startedAt atomic.Int64
+}
+
+// Bytes 已传输字节数
+func (t *TransferStats) Bytes() int64 {
+	return t.bytes.Load()
+}
+
+// Limit 本次传输生效的限速（字节/秒），0 表示不限速
+func (t *TransferStats) Limit() int64 {
+	return t.limit.Load()
+}
+
+// Rate 从开始传输到现在的平均速率（字节/秒）
+func (t *TransferStats) Rate() int64 {
+	started := t.startedAt.Load()
+	if started == 0 {
+		return 0
+	}
+	elapsed := time.Since(time.Unix(0, started)).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(t.bytes.Load()) / elapsed)
+}
+
+// begin 标记传输开始；同一个 TransferStats 可跨多次传输（如分卷）累计
+func (t *TransferStats) begin(limit int64) {
+	t.limit.Store(limit)
+	t.startedAt.CompareAndSwap(0, time.Now().UnixNano())
+}
+
+// rateLimiter 令牌桶限速器，桶容量为一秒的流量
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{
+		rate: float64(bytesPerSec),
+		last: time.Now(),
+	}
+}
+
+// wait 阻塞直到可以消费 n 个字节的令牌
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// meteredReader 对读取进行限速并累计传输统计
+type meteredReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+	stats   *TransferStats
+}
+
+// maxReadChunk 限速时单次读取的上限，避免一次性透支大量令牌造成突发
+const maxReadChunk = 32 * 1024
+
+// NewMeteredReader 返回按 bytesPerSec 限速（0 表示不限速）并将读取字节计入 stats 的 Reader
+func NewMeteredReader(ctx context.Context, r io.Reader, bytesPerSec int64, stats *TransferStats) io.Reader {
+	if stats == nil {
+		stats = &TransferStats{}
+	}
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	stats.begin(bytesPerSec)
+
+	m := &meteredReader{ctx: ctx, r: r, stats: stats}
+	if bytesPerSec > 0 {
+		m.limiter = newRateLimiter(bytesPerSec)
+	}
+	return m
+}
+
+func (m *meteredReader) Read(p []byte) (int, error) {
+	if m.limiter != nil && len(p) > maxReadChunk {
+		p = p[:maxReadChunk]
+	}
+	n, err := m.r.Read(p)
+	if n > 0 {
+		m.stats.bytes.Add(int64(n))
+		if m.limiter != nil {
+			if werr := m.limiter.wait(m.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
+
+// meteredReadCloser 为下载流附加限速并保留 Close
+type meteredReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (m *meteredReadCloser) Close() error {
+	return m.closer.Close()
+}
@@ -0,0 +1,2 @@
+-- 备份清单：分卷、校验值等元数据
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS manifest JSONB;
@@ -0,0 +1,7 @@
+-- 去重仓库中已存储的块
+CREATE TABLE IF NOT EXISTS repository_chunks (
+    id CHAR(64) PRIMARY KEY,
+    size BIGINT NOT NULL,
+    stored_size BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
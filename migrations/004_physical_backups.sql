@@ -0,0 +1,5 @@
+-- 备份种类与物理备份的 WAL 位置
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'logical';
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS start_lsn VARCHAR(32);
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS stop_lsn VARCHAR(32);
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS timeline INTEGER;
@@ -0,0 +1,13 @@
+-- WAL 归档记录
+CREATE TABLE IF NOT EXISTS wal_segments (
+    name VARCHAR(64) PRIMARY KEY,
+    timeline INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    raw_sha256 CHAR(64) NOT NULL,
+    compression VARCHAR(20) NOT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_wal_segments_timeline ON wal_segments(timeline, name);
@@ -0,0 +1,4 @@
+-- 增量物理备份的父备份，失败的子备份不阻止父备份被删除
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS parent_id BIGINT REFERENCES backup_records(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_backup_records_parent_id ON backup_records(parent_id);
@@ -0,0 +1,3 @@
+-- 逻辑备份的对象范围（schema/表/扩展的包含与排除）
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS selection JSONB;
+ALTER TABLE scheduled_jobs ADD COLUMN IF NOT EXISTS selection JSONB;
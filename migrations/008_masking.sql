@@ -0,0 +1,22 @@
+-- 脱敏配置
+CREATE TABLE IF NOT EXISTS masking_profiles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    rules JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 脱敏执行审计，配置或备份删除后保留记录
+CREATE TABLE IF NOT EXISTS masking_audit (
+    id SERIAL PRIMARY KEY,
+    profile_id INTEGER REFERENCES masking_profiles(id) ON DELETE SET NULL,
+    profile_name VARCHAR(255) NOT NULL,
+    backup_id BIGINT REFERENCES backup_records(id) ON DELETE SET NULL,
+    database VARCHAR(255) NOT NULL,
+    rules INTEGER NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    error TEXT,
+    applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_masking_audit_applied_at ON masking_audit(applied_at);
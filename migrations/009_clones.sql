@@ -0,0 +1,22 @@
+-- 备份所属的目标数据库，旧记录为空表示配置中的数据库
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS target VARCHAR(255);
+
+-- 脱敏审计记录目标服务器
+ALTER TABLE masking_audit ADD COLUMN IF NOT EXISTS server VARCHAR(255);
+
+-- 从备份克隆出的数据库，过期后自动删除
+CREATE TABLE IF NOT EXISTS clones (
+    id SERIAL PRIMARY KEY,
+    target VARCHAR(255) NOT NULL,
+    backup_id BIGINT REFERENCES backup_records(id) ON DELETE SET NULL,
+    server VARCHAR(255),
+    database VARCHAR(255) NOT NULL,
+    masking_profile VARCHAR(255),
+    status VARCHAR(50) NOT NULL,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    dropped_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_clones_expires_at ON clones(expires_at) WHERE dropped_at IS NULL;
@@ -0,0 +1,6 @@
+-- 逻辑备份的规范化 schema，用于比较两个备份之间的结构变化
+CREATE TABLE IF NOT EXISTS schema_snapshots (
+    backup_id BIGINT PRIMARY KEY REFERENCES backup_records(id) ON DELETE CASCADE,
+    snapshot JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,18 @@
+-- 备份时源数据库的总大小
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS database_bytes BIGINT;
+
+-- 备份时每张用户表的行数估计和大小
+CREATE TABLE IF NOT EXISTS backup_table_stats (
+    backup_id BIGINT NOT NULL REFERENCES backup_records(id) ON DELETE CASCADE,
+    schema_name VARCHAR(255) NOT NULL,
+    table_name VARCHAR(255) NOT NULL,
+    row_estimate BIGINT NOT NULL,
+    live_tuples BIGINT NOT NULL,
+    dead_tuples BIGINT NOT NULL,
+    total_bytes BIGINT NOT NULL,
+    table_bytes BIGINT NOT NULL,
+    index_bytes BIGINT NOT NULL,
+    PRIMARY KEY (backup_id, schema_name, table_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_backup_table_stats_table ON backup_table_stats(schema_name, table_name);
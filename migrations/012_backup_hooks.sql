@@ -0,0 +1,5 @@
+-- 定时任务的备份前后钩子
+ALTER TABLE scheduled_jobs ADD COLUMN IF NOT EXISTS hooks JSONB;
+
+-- 备份运行日志，记录钩子的执行结果和输出
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS run_log TEXT;
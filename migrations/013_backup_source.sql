@@ -0,0 +1,2 @@
+-- 实际执行备份的主机（host:port），从备库备份时为备库地址
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS source_host VARCHAR(255);
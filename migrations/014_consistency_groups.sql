@@ -0,0 +1,13 @@
+-- 基于同一事务快照的一组逻辑备份
+CREATE TABLE IF NOT EXISTS consistency_groups (
+    id SERIAL PRIMARY KEY,
+    databases TEXT[] NOT NULL,
+    snapshot TEXT,
+    status VARCHAR(50) NOT NULL,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS group_id BIGINT REFERENCES consistency_groups(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_backup_records_group_id ON backup_records(group_id);
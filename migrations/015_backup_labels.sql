@@ -0,0 +1,15 @@
+-- 备份的标签和备注，便于按用途查找备份
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS labels TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS notes TEXT;
+
+-- 备份大小（字节），size 为格式化后的文本，按大小筛选时使用此列；已有记录从清单回填
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS size_bytes BIGINT;
+UPDATE backup_records SET size_bytes = (manifest->>'size')::BIGINT
+WHERE size_bytes IS NULL AND manifest ? 'size';
+
+-- 由定时任务创建的备份记录所属的任务
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS job_id BIGINT REFERENCES scheduled_jobs(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_backup_records_labels ON backup_records USING GIN (labels);
+CREATE INDEX IF NOT EXISTS idx_backup_records_timestamp ON backup_records(timestamp);
+CREATE INDEX IF NOT EXISTS idx_backup_records_job_id ON backup_records(job_id);
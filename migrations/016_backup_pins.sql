@@ -0,0 +1,7 @@
+-- 固定的备份不受保留策略清理，pin_expires_at 为空表示永久固定
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS pinned_by VARCHAR(255);
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS pin_reason TEXT;
+ALTER TABLE backup_records ADD COLUMN IF NOT EXISTS pin_expires_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_backup_records_pinned ON backup_records(pinned_at) WHERE pinned_at IS NOT NULL;
@@ -0,0 +1,14 @@
+-- 定时任务的完整备份参数和保留策略，options 取代只保存对象范围和钩子的 selection、hooks 两列
+ALTER TABLE scheduled_jobs ADD COLUMN IF NOT EXISTS options JSONB;
+ALTER TABLE scheduled_jobs ADD COLUMN IF NOT EXISTS retention JSONB;
+
+-- 已有任务按原来固定使用的参数（数据、结构、压缩）迁移
+UPDATE scheduled_jobs
+SET options = jsonb_strip_nulls(jsonb_build_object(
+    'includeData', true,
+    'includeSchema', true,
+    'compression', true,
+    'selection', selection,
+    'hooks', hooks
+))
+WHERE options IS NULL;
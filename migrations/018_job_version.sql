@@ -0,0 +1,2 @@
+-- 定时任务的版本号，更新时用于检测并发修改
+ALTER TABLE scheduled_jobs ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
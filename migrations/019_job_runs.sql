@@ -0,0 +1,16 @@
+-- 定时任务的运行记录：触发方式、起止时间、结果和对应的备份
+CREATE TABLE IF NOT EXISTS job_runs (
+    id SERIAL PRIMARY KEY,
+    job_id BIGINT NOT NULL REFERENCES scheduled_jobs(id) ON DELETE CASCADE,
+    backup_id BIGINT REFERENCES backup_records(id) ON DELETE SET NULL,
+    trigger_type VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_job_id ON job_runs(job_id, started_at DESC);
+
+-- 运行失败后的重试次数
+ALTER TABLE scheduled_jobs ADD COLUMN IF NOT EXISTS retries INTEGER NOT NULL DEFAULT 0;
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -19,23 +20,38 @@ type DBConfig struct {
 	SSLMode  string
 }
 
-// BuildConnectionString 构建PostgreSQL连接字符串
+// BuildConnectionString 构建PostgreSQL连接字符串，所有值都加引号转义，SSLMode 为空时为 disable
 func BuildConnectionString(config DBConfig) string {
+	sslMode := config.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
 	return fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		config.Host,
+		quoteConnValue(config.Host),
 		config.Port,
-		config.User,
-		config.Password,
-		config.DBName,
-		config.SSLMode,
+		quoteConnValue(config.User),
+		quoteConnValue(config.Password),
+		quoteConnValue(config.DBName),
+		quoteConnValue(sslMode),
 	)
 }
 
+// quoteConnValue 按 libpq 连接字符串的规则为值加单引号，转义其中的反斜杠和单引号
+func quoteConnValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	return "'" + strings.ReplaceAll(v, "'", `\'`) + "'"
+}
+
+// OpenDatabase 按配置打开数据库连接，不设置连接池参数也不测试连接，适用于短时使用的连接
+func OpenDatabase(config DBConfig) (*sql.DB, error) {
+	return sql.Open("postgres", BuildConnectionString(config))
+}
+
 // ConnectDatabase 连接数据库并设置连接池参数
 func ConnectDatabase(config DBConfig) (*sql.DB, error) {
 	// 建立连接
-	db, err := sql.Open("postgres", BuildConnectionString(config))
+	db, err := OpenDatabase(config)
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %v", err)
 	}
@@ -1,5 +1,5 @@
 import React, { useState, useEffect } from 'react';
-import { Database, HardDrive, Cloud, Play, Clock, CheckCircle, XCircle, Settings, Download, Trash2, RefreshCw, Calendar, Plus, Edit, Pause, Power } from 'lucide-react';
+import { Database, HardDrive, Cloud, Play, Clock, CheckCircle, XCircle, Settings, Download, Trash2, RefreshCw, Calendar, Plus, Edit, Pause, Power, Pin } from 'lucide-react';
 
 const PostgreSQLBackupInterface = () => {
   const [activeTab, setActiveTab] = useState('backup');
@@ -22,7 +22,13 @@ const PostgreSQLBackupInterface = () => {
       size: '1.2 GB',
       status: 'completed',
       timestamp: '2025-05-19 02:00:15',
-      path: 's3://my-backups/postgresql/weekly_backup_20250519_020015.sql'
+      path: 's3://my-backups/postgresql/weekly_backup_20250519_020015.sql',
+      pin: {
+        by: 'ops-oncall',
+        reason: '4.2 版本升级前',
+        pinnedAt: '2025-05-19 09:30:00',
+        expiresAt: null
+      }
     },
     {
       id: 3,
@@ -69,7 +75,13 @@ const PostgreSQLBackupInterface = () => {
       enabled: true,
       lastRun: '2025-05-26 02:00:15',
       nextRun: '2025-05-27 02:00:00',
-      status: 'active'
+      status: 'active',
+      stats: {
+        runs: 30,
+        successRate: 0.967,
+        avgDuration: '4m12s',
+        lastFailure: 'pg_dump: error: connection to server failed'
+      }
     },
     {
       id: 2,
@@ -131,6 +143,34 @@ const PostgreSQLBackupInterface = () => {
     setBackupHistory(prev => prev.filter(backup => backup.id !== id));
   };
 
+  // 固定的备份不受保留策略清理，取消固定后重新按保留天数清理
+  const togglePin = async (backup) => {
+    let body;
+    if (!backup.pin) {
+      const reason = prompt('固定原因（如：升级前、季度末）');
+      if (!reason) return;
+      body = JSON.stringify({ reason });
+    }
+    try {
+      const res = await fetch(`/api/v1/backups/${backup.id}/pin`, {
+        method: backup.pin ? 'DELETE' : 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body
+      });
+      const data = await res.json();
+      if (!res.ok) {
+        alert(`操作失败 ❌：${data.error || '未知错误'}`);
+        return;
+      }
+      setBackupHistory(prev =>
+        prev.map(b => (b.id === backup.id ? { ...b, pin: backup.pin ? null : data } : b))
+      );
+    } catch (err) {
+      alert('操作失败 ❌：网络错误或服务器未响应');
+      console.error(err);
+    }
+  };
+
   const toggleJob = (id) => {
     setScheduledJobs(prev => 
       prev.map(job => 
@@ -141,6 +181,18 @@ const PostgreSQLBackupInterface = () => {
     );
   };
 
+  // 立即按任务保存的参数运行一次，记为手动运行
+  const runJobNow = async (id) => {
+    try {
+      const res = await fetch(`/api/v1/jobs/${id}/run`, { method: 'POST' });
+      const data = await res.json();
+      alert(res.ok ? '任务已开始运行 ✅' : `运行失败 ❌：${data.error || '未知错误'}`);
+    } catch (err) {
+      alert('运行失败 ❌：网络错误或服务器未响应');
+      console.error(err);
+    }
+  };
+
   const deleteJob = (id) => {
     setScheduledJobs(prev => prev.filter(job => job.id !== id));
   };
@@ -408,6 +460,15 @@ const PostgreSQLBackupInterface = () => {
                           <h3 className="text-white font-semibold">{job.name}</h3>
                           <p className="text-slate-300 text-sm">{job.scheduleText}</p>
                           <p className="text-slate-400 text-xs">Cron: {job.schedule}</p>
+                          {job.stats && (
+                            <p className="text-slate-400 text-xs">
+                              近 30 天成功率 {Math.round(job.stats.successRate * 100)}%（{job.stats.runs} 次）
+                              {job.stats.avgDuration && ` · 平均耗时 ${job.stats.avgDuration}`}
+                            </p>
+                          )}
+                          {job.stats?.lastFailure && (
+                            <p className="text-red-400 text-xs">最近失败：{job.stats.lastFailure}</p>
+                          )}
                         </div>
                       </div>
                       <div className="flex items-center gap-6">
@@ -420,6 +481,13 @@ const PostgreSQLBackupInterface = () => {
                           <p className="text-white text-sm">{job.nextRun}</p>
                         </div>
                         <div className="flex gap-2">
+                          <button
+                            onClick={() => runJobNow(job.id)}
+                            title="立即运行"
+                            className="p-2 text-blue-400 hover:text-blue-300 hover:bg-blue-500/20 rounded-lg transition-colors"
+                          >
+                            <Play className="w-4 h-4" />
+                          </button>
                           <button
                             onClick={() => toggleJob(job.id)}
                             className={`p-2 rounded-lg transition-colors ${
@@ -488,6 +556,13 @@ const PostgreSQLBackupInterface = () => {
                         <div>
                           <h3 className="text-white font-semibold">{backup.name}</h3>
                           <p className="text-slate-300 text-sm">{backup.path}</p>
+                          {backup.pin && (
+                            <p className="text-amber-400 text-sm flex items-center gap-1 mt-1">
+                              <Pin className="w-3 h-3" />
+                              {backup.pin.by} 于 {backup.pin.pinnedAt} 固定：{backup.pin.reason}
+                              {backup.pin.expiresAt ? `（至 ${backup.pin.expiresAt}）` : '（永久）'}
+                            </p>
+                          )}
                         </div>
                       </div>
                       <div className="flex items-center gap-4">
@@ -501,8 +576,18 @@ const PostgreSQLBackupInterface = () => {
                               <Download className="w-4 h-4" />
                             </button>
                           )}
+                          {backup.status === 'completed' && (
+                            <button
+                              onClick={() => togglePin(backup)}
+                              title={backup.pin ? '取消固定' : '固定备份'}
+                              className={`p-2 rounded-lg transition-colors ${backup.pin ? 'text-amber-400 hover:text-amber-300 hover:bg-amber-500/20' : 'text-slate-400 hover:text-slate-300 hover:bg-slate-500/20'}`}
+                            >
+                              <Pin className="w-4 h-4" />
+                            </button>
+                          )}
                           <button 
                             onClick={() => deleteBackup(backup.id)}
+                            disabled={!!backup.pin}
                             className="p-2 text-red-400 hover:text-red-300 hover:bg-red-500/20 rounded-lg transition-colors"
                           >
                             <Trash2 className="w-4 h-4" />